@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExeInfo describes the contents of an ELF, PE or Mach-O executable
+type ExeInfo struct {
+	Format      string
+	Arch        string
+	Linking     string
+	Interpreter string   `json:",omitempty"`
+	Libraries   []string `json:",omitempty"`
+	Stripped    bool
+	RELRO       string `json:",omitempty"`
+	PIE         bool
+	NX          bool
+}
+
+// Summarise the executable as key=value pairs so -m patterns can be precise
+func (info *ExeInfo) String() string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	fields := []string{info.Format, info.Arch, info.Linking}
+	if info.Interpreter != "" {
+		fields = append(fields, "interp="+info.Interpreter)
+	}
+	if len(info.Libraries) > 0 {
+		fields = append(fields, "libs="+strings.Join(info.Libraries, ","))
+	}
+	fields = append(fields, "stripped="+yesNo(info.Stripped))
+	if info.RELRO != "" {
+		fields = append(fields, "relro="+info.RELRO)
+	}
+	fields = append(fields, "pie="+yesNo(info.PIE), "nx="+yesNo(info.NX))
+	return strings.Join(fields, " ")
+}
+
+// Whether extractFileData looks inside executables, only worth doing when
+// the metadata pattern, verbose listing or structured output shows it
+var inspectExecutables = true
+
+// Inspect an executable whose leading bytes are in buf and size is that of
+// the file. Returns nil for anything which is not a readable ELF, PE or
+// Mach-O file.
+func inspectExecutable(r io.ReaderAt, buf []byte, size int64) *ExeInfo {
+	var info *ExeInfo
+	var err error
+
+	switch {
+	case bytes.HasPrefix(buf, []byte(elf.ELFMAG)):
+		info, err = inspectELF(r, size)
+	case bytes.HasPrefix(buf, []byte("MZ")):
+		info, err = inspectPE(r)
+	case len(buf) >= 4 && isMachOMagic(binary.BigEndian.Uint32(buf)):
+		info, err = inspectMachO(r)
+	}
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+func isMachOMagic(magic uint32) bool {
+	switch magic {
+	case macho.Magic32, macho.Magic64, macho.MagicFat, 0xcefaedfe, 0xcffaedfe:
+		return true
+	}
+	return false
+}
+
+func inspectELF(r io.ReaderAt, size int64) (*ExeInfo, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &ExeInfo{Format: "elf", Arch: elfArch(f), RELRO: "none"}
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_INTERP:
+			// The header is untrusted, never allocate more than the file holds
+			if prog.Filesz > uint64(size) {
+				return nil, fmt.Errorf("interpreter of %d bytes in a %d byte file", prog.Filesz, size)
+			}
+			data, err := io.ReadAll(io.LimitReader(prog.Open(), int64(prog.Filesz)))
+			if err == nil {
+				info.Interpreter = strings.TrimRight(string(data), "\x00")
+			}
+		case elf.PT_GNU_RELRO:
+			info.RELRO = "partial"
+		case elf.PT_GNU_STACK:
+			info.NX = prog.Flags&elf.PF_X == 0
+		}
+	}
+
+	info.Libraries, _ = f.ImportedLibraries()
+	info.Linking = "static"
+	if info.Interpreter != "" || len(info.Libraries) > 0 {
+		info.Linking = "dynamic"
+	}
+	info.Stripped = f.Section(".symtab") == nil
+
+	var flags, flags1 uint64
+	bindNow := false
+	for _, entry := range elfDynamicEntries(f) {
+		switch elf.DynTag(entry[0]) {
+		case elf.DT_BIND_NOW:
+			bindNow = true
+		case elf.DT_FLAGS:
+			flags = entry[1]
+		case elf.DT_FLAGS_1:
+			flags1 = entry[1]
+		}
+	}
+	if flags&uint64(elf.DF_BIND_NOW) != 0 || flags1&uint64(elf.DF_1_NOW) != 0 {
+		bindNow = true
+	}
+	if info.RELRO == "partial" && bindNow {
+		info.RELRO = "full"
+	}
+
+	// Shared libraries are ET_DYN too, only executables have an interpreter or the PIE flag
+	info.PIE = f.Type == elf.ET_DYN && (info.Interpreter != "" || flags1&uint64(elf.DF_1_PIE) != 0)
+
+	return info, nil
+}
+
+// Read the tag/value pairs of the .dynamic section
+func elfDynamicEntries(f *elf.File) [][2]uint64 {
+	section := f.Section(".dynamic")
+	if section == nil {
+		return nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil
+	}
+
+	var entries [][2]uint64
+	if f.Class == elf.ELFCLASS64 {
+		for len(data) >= 16 {
+			entries = append(entries, [2]uint64{f.ByteOrder.Uint64(data[0:8]), f.ByteOrder.Uint64(data[8:16])})
+			data = data[16:]
+		}
+	} else {
+		for len(data) >= 8 {
+			entries = append(entries, [2]uint64{uint64(f.ByteOrder.Uint32(data[0:4])), uint64(f.ByteOrder.Uint32(data[4:8]))})
+			data = data[8:]
+		}
+	}
+	return entries
+}
+
+func elfArch(f *elf.File) string {
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_386:
+		return "i386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_RISCV:
+		if f.Class == elf.ELFCLASS64 {
+			return "riscv64"
+		}
+		return "riscv32"
+	case elf.EM_PPC64:
+		return "ppc64"
+	case elf.EM_S390:
+		return "s390x"
+	}
+	return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+}
+
+func inspectPE(r io.ReaderAt) (*ExeInfo, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &ExeInfo{Format: "pe", Arch: peArch(f.Machine), Linking: "static"}
+	info.Libraries, _ = f.ImportedLibraries()
+	if len(info.Libraries) > 0 {
+		info.Linking = "dynamic"
+	}
+	info.Stripped = f.NumberOfSymbols == 0
+
+	var dllCharacteristics uint16
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dllCharacteristics = header.DllCharacteristics
+	case *pe.OptionalHeader64:
+		dllCharacteristics = header.DllCharacteristics
+	}
+	info.PIE = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE != 0
+	info.NX = dllCharacteristics&pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT != 0
+
+	return info, nil
+}
+
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "x86_64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "i386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	}
+	return fmt.Sprintf("0x%04x", machine)
+}
+
+func inspectMachO(r io.ReaderAt) (*ExeInfo, error) {
+	f, err := macho.NewFile(r)
+	if err == nil {
+		defer f.Close()
+		return machOInfo(f, machOArch(f.Cpu)), nil
+	}
+
+	// Universal binaries carry one image per architecture, describe the first
+	fat, err := macho.NewFatFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer fat.Close()
+	if len(fat.Arches) == 0 {
+		return nil, fmt.Errorf("empty universal binary")
+	}
+	arches := make([]string, len(fat.Arches))
+	for i, arch := range fat.Arches {
+		arches[i] = machOArch(arch.Cpu)
+	}
+	return machOInfo(fat.Arches[0].File, strings.Join(arches, ",")), nil
+}
+
+func machOInfo(f *macho.File, arch string) *ExeInfo {
+	const loadDylinker = 0xe
+
+	info := &ExeInfo{Format: "macho", Arch: arch, Linking: "static"}
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 12 || f.ByteOrder.Uint32(raw[0:4]) != loadDylinker {
+			continue
+		}
+		if offset := f.ByteOrder.Uint32(raw[8:12]); int(offset) < len(raw) {
+			info.Interpreter = strings.TrimRight(string(raw[offset:]), "\x00")
+		}
+	}
+	info.Libraries, _ = f.ImportedLibraries()
+	if info.Interpreter != "" || len(info.Libraries) > 0 {
+		info.Linking = "dynamic"
+	}
+	info.Stripped = f.Symtab == nil || len(f.Symtab.Syms) == 0
+	info.PIE = f.Flags&macho.FlagPIE != 0
+	info.NX = f.Flags&macho.FlagAllowStackExecution == 0
+	return info
+}
+
+func machOArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return strings.ToLower(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
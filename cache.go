@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Bumped whenever Metadata or the way it is computed changes
+const metadataCacheVersion = 3
+
+// cacheKey identifies a file independently of its path
+type cacheKey struct {
+	Dev uint64
+	Ino uint64
+}
+
+// cachedMetadata is the metadata of a file as it was when last extracted,
+// and the absolute path it was found at
+type cachedMetadata struct {
+	Path       string
+	Size       int64
+	ModTime    int64
+	ChangeTime int64
+	Meta       Metadata
+}
+
+// metadataCache remembers the extracted metadata of files between runs so
+// only files which changed are read, sniffed and looked up again
+type metadataCache struct {
+	Version int
+	Entries map[cacheKey]cachedMetadata
+
+	path  string
+	dirty bool
+	seen  map[cacheKey]bool
+}
+
+// Load the metadata cache from the user cache directory, starting afresh
+// when there is none or it was written by another version
+func openMetadataCache() (*metadataCache, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	cache := &metadataCache{path: filepath.Join(dir, "metadata.gob"), seen: make(map[cacheKey]bool)}
+
+	if file, err := os.Open(cache.path); err == nil {
+		defer file.Close()
+		if gob.NewDecoder(bufio.NewReader(file)).Decode(cache) != nil || cache.Version != metadataCacheVersion {
+			cache.Entries = nil
+		}
+	}
+	if cache.Entries == nil {
+		cache.Version = metadataCacheVersion
+		cache.Entries = make(map[cacheKey]cachedMetadata)
+	}
+	return cache, nil
+}
+
+// Return the cached metadata of a file when its inode is unchanged,
+// otherwise extract it and remember it for the next run. info is what the
+// walker found at the path of the file, as for extractFileData.
+func (cache *metadataCache) extract(file *os.File, info os.FileInfo) (Metadata, bool, error) {
+	if info == nil || info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if info, err = file.Stat(); err != nil {
+			return extractFileData(file, nil)
+		}
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return extractFileData(file, info)
+	}
+
+	key := cacheKey{Dev: uint64(stat.Dev), Ino: uint64(stat.Ino)}
+	cache.seen[key] = true
+	if entry, ok := cache.Entries[key]; ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() && entry.ChangeTime == changeTime(info) {
+		return entry.Meta, entry.Meta.Kind == kindBinary, nil
+	}
+
+	metadata, isBinary, err := extractFileData(file, info)
+	if err != nil {
+		return metadata, isBinary, err
+	}
+
+	// Keyed by inode, so a changed file replaces its own stale entry
+	path, _ := filepath.Abs(file.Name())
+	cache.Entries[key] = cachedMetadata{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), ChangeTime: changeTime(info), Meta: metadata}
+	cache.dirty = true
+	return metadata, isBinary, nil
+}
+
+// Forget the entries of files below the searched roots which were not seen
+// and are gone, or whose inode now belongs to another file, so the cache
+// does not keep every file which ever existed
+func (cache *metadataCache) prune(roots []string) {
+	var absRoots []string
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			absRoots = append(absRoots, abs)
+		}
+	}
+
+	for key, entry := range cache.Entries {
+		if cache.seen[key] || !underAny(entry.Path, absRoots) {
+			continue
+		}
+		if info, err := os.Stat(entry.Path); err == nil {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && uint64(stat.Dev) == key.Dev && uint64(stat.Ino) == key.Ino {
+				continue
+			}
+		}
+		delete(cache.Entries, key)
+		cache.dirty = true
+	}
+}
+
+// Utility function to test whether path is one of the roots or below one
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the cache back if anything was added, renaming it into place
+func (cache *metadataCache) save() error {
+	if !cache.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0755); err != nil {
+		return err
+	}
+	err := writeAtomic(cache.path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(cache)
+	}, nil)
+	if err == nil {
+		cache.dirty = false
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content kinds reported in Metadata.Kind
+const (
+	kindEmpty  = "empty"
+	kindText   = "text"
+	kindBinary = "binary"
+)
+
+// Number of leading bytes inspected by the classifier, the same window git uses
+const classifySampleSize = 8000
+
+// Minimum share of printable bytes for content to be considered text
+const textRatio = 0.95
+
+// MIME types outside text/ which are nevertheless human readable
+var textMimeTypes = map[string]bool{
+	"application/ecmascript":    true,
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/ld+json":       true,
+	"application/sql":           true,
+	"application/toml":          true,
+	"application/x-httpd-php":   true,
+	"application/x-javascript":  true,
+	"application/x-perl":        true,
+	"application/x-python":      true,
+	"application/x-ruby":        true,
+	"application/x-sh":          true,
+	"application/x-shellscript": true,
+	"application/x-yaml":        true,
+	"application/xhtml+xml":     true,
+	"application/xml":           true,
+	"application/yaml":          true,
+	"image/svg+xml":             true,
+	"message/rfc822":            true,
+}
+
+// MIME type prefixes which are always binary whatever the bytes look like
+var binaryMimePrefixes = []string{
+	"application/pdf",
+	"application/vnd.",
+	"application/wasm",
+	"application/x-gzip",
+	"application/zip",
+	"audio/",
+	"font/",
+	"image/",
+	"video/",
+}
+
+// Decide whether a file is text, binary or empty from a sample of its
+// content together with the sniffed MIME type and text encoding
+func classifyContent(buf []byte, mimeType string, encoding string) string {
+	if len(buf) == 0 {
+		return kindEmpty
+	}
+
+	// UTF-16 is NUL heavy by nature, trust the encoding detector
+	if strings.HasPrefix(encoding, "utf-16") {
+		return kindText
+	}
+
+	sample := buf
+	if len(sample) > classifySampleSize {
+		sample = sample[:classifySampleSize]
+	}
+
+	// A NUL byte is the strongest binary signal there is
+	for _, b := range sample {
+		if b == 0 {
+			return kindBinary
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = mimeType
+	}
+	if strings.HasPrefix(mediaType, "text/") || textMimeTypes[mediaType] || strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json") {
+		return kindText
+	}
+	for _, prefix := range binaryMimePrefixes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return kindBinary
+		}
+	}
+
+	if printableRatio(sample, encoding == encodingLatin1) >= textRatio {
+		return kindText
+	}
+	return kindBinary
+}
+
+// Share of the sample made up of printable runes and common whitespace.
+// Bytes which are not valid UTF-8 only count when latin1 is allowed.
+func printableRatio(sample []byte, latin1 bool) float64 {
+	printable := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// A multi-byte sequence cut off by the sample window is not an error
+			if len(sample)-i < utf8.UTFMax && !utf8.FullRune(sample[i:]) {
+				printable += len(sample) - i
+				size = len(sample) - i
+			} else if latin1 && sample[i] >= 0xa0 {
+				printable++
+			}
+		case r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\b' || r == 0x1b:
+			printable += size
+		case r >= 0x20 && r != 0x7f && !(r >= 0x80 && r < 0xa0):
+			printable += size
+		}
+		i += size
+	}
+	return float64(printable) / float64(len(sample))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SGR sequences of each part of the output, keyed like LS_COLORS and
+// overridden by FFS_COLORS, e.g. FFS_COLORS="di=1;34:nu=33"
+var palette = defaultPalette()
+
+func defaultPalette() map[string]string {
+	return map[string]string{
+		"di": "32",       // directories
+		"fi": "38;5;117", // files
+		"ln": "38;5;221", // symbolic links
+		"ex": "38;5;219", // owner executable files
+		"eg": "38;5;211", // group executable files
+		"ew": "38;5;124", // world executable files
+		"su": "31",       // mode of setuid files
+		"ca": "35",       // mode and capabilities of files with capabilities
+		"pa": "38;5;221", // path before a matched line
+		"nu": "38;5;39",  // line numbers
+		"mt": "38;5;8",   // matched lines
+		"hd": "36",       // summary headings
+		"hi": "33",       // highlighted summary figures
+		"dm": "90",       // details and errors
+		"ad": "32",       // added lines, new matches and kept duplicates
+		"rm": "31",       // removed lines, lost matches and dropped duplicates
+		"ch": "33",       // changed matches
+		"bo": "1",        // diff file headers
+		"er": "31",       // errors of the interactive browser
+		"hl": "1;30;43",  // matches highlighted by the interactive browser
+	}
+}
+
+// Whether output is colored, decided by setupColor
+var useColor = true
+
+// Decide whether to color output. auto colors a terminal unless NO_COLOR
+// is set, always and never do what they say.
+func setupColor(mode string) error {
+	switch mode {
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	case "auto":
+		info, err := os.Stdout.Stat()
+		useColor = err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+	default:
+		return fmt.Errorf("unknown --color '%s'", mode)
+	}
+	palette = defaultPalette()
+	return parsePalette(os.Getenv("FFS_COLORS"))
+}
+
+// Parse colon separated key=SGR entries into the palette
+func parsePalette(spec string) error {
+	for _, entry := range strings.Split(spec, ":") {
+		if entry == "" {
+			continue
+		}
+		key, code, ok := strings.Cut(entry, "=")
+		if !ok || strings.Trim(code, "0123456789;") != "" {
+			return fmt.Errorf("invalid FFS_COLORS entry '%s'", entry)
+		}
+		if _, known := palette[key]; !known {
+			return fmt.Errorf("unknown FFS_COLORS key '%s'", key)
+		}
+		palette[key] = code
+	}
+	return nil
+}
+
+// Wrap text in the color of a palette key, when output is colored
+func colorize(key string, text string) string {
+	return paint(palette[key], text)
+}
+
+// Wrap text in an SGR sequence, when output is colored
+func paint(code string, text string) string {
+	if !useColor || code == "" {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// Print a matched line after the path of its file and its line number
+func printMatchLine(path string, match Match) {
+	fmt.Printf("%s:%s:%s\n", colorize("pa", path), colorize("nu", strconv.Itoa(match.Line)), colorize("mt", replaceNonPrintable(match.Text)))
+}
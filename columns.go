@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Columns of the verbose listing when --columns is not given
+const defaultColumns = "mode,owner,group,size,mtime,mime,name"
+
+// Narrowest a column is shrunk to when fitting the terminal
+const minColumnWidth = 8
+
+// Room kept for the last column, which is never padded
+const lastColumnWidth = 20
+
+// Named --time-format layouts, anything else is a Go time layout
+var timeFormats = map[string]string{
+	"default": "2006-01-02 15:04:05",
+	"iso":     time.RFC3339,
+	"rfc3339": time.RFC3339,
+	"date":    "2006-01-02",
+	"ls":      "Jan _2 15:04",
+}
+
+// column is one field of the verbose listing
+type column struct {
+	name  string
+	width int
+	right bool // numbers line up on the right
+	flex  bool // shrinks to fit the terminal
+	value func(meta Metadata, info os.FileInfo) string
+}
+
+var listColumns []column
+var timeFormat string
+var humanSizes bool
+
+// Parse a comma separated list of column names
+func parseColumns(spec string) ([]column, error) {
+	var columns []column
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		name := strings.ToLower(field)
+		if strings.HasPrefix(name, "exif.") {
+			// EXIF tags keep their case, Model not model
+			name = "exif." + field[len("exif."):]
+		}
+		if name == "" {
+			continue
+		}
+		c, err := newColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns given")
+	}
+	return columns, nil
+}
+
+func newColumn(name string) (column, error) {
+	text := func(width int, flex bool, value func(meta Metadata) string) column {
+		return column{name: name, width: width, flex: flex, value: func(meta Metadata, info os.FileInfo) string {
+			return value(meta)
+		}}
+	}
+
+	switch name {
+	case "mode":
+		return text(modeWidth, false, func(meta Metadata) string {
+			if meta.ACL != "" {
+				// Mark files with an access control list the way ls does
+				return meta.Mode + "+"
+			}
+			return meta.Mode
+		}), nil
+	case "owner":
+		return text(ownerWidth, true, func(meta Metadata) string { return meta.Owner }), nil
+	case "group":
+		return text(groupWidth, true, func(meta Metadata) string { return meta.Group }), nil
+	case "size":
+		c := text(sizeWidth, false, func(meta Metadata) string {
+			if humanSizes {
+				return humanizeBytes(meta.Size)
+			}
+			return fmt.Sprintf("%d", meta.Size)
+		})
+		c.right = true
+		return c, nil
+	case "mtime":
+		width := len(formatModTime(Metadata{}, nil))
+		if timeFormat == "relative" {
+			width = len("59 minutes ago")
+		}
+		return column{name: name, width: width, value: formatModTime}, nil
+	case "mime":
+		return text(mimeTypeWidth, true, func(meta Metadata) string { return meta.MimeType }), nil
+	case "description":
+		return text(mimeTypeWidth, true, func(meta Metadata) string { return meta.Description }), nil
+	case "encoding":
+		return text(10, false, func(meta Metadata) string { return meta.Encoding }), nil
+	case "kind":
+		return text(6, false, func(meta Metadata) string { return meta.Kind }), nil
+	case "link":
+		return text(mimeTypeWidth, true, func(meta Metadata) string { return meta.Link }), nil
+	case "exe":
+		return text(40, true, func(meta Metadata) string {
+			if meta.Exe == nil {
+				return ""
+			}
+			return meta.Exe.String()
+		}), nil
+	case "acl":
+		return text(20, true, func(meta Metadata) string { return meta.ACL }), nil
+	case "caps":
+		return text(20, true, func(meta Metadata) string { return meta.Capabilities }), nil
+	case "xattrs":
+		return text(20, true, func(meta Metadata) string { return strings.Join(meta.Xattrs, ",") }), nil
+	case "name", "path":
+		// Filled in by printResults, which knows the directory and colors
+		return column{name: name, width: pathWidth, flex: true}, nil
+	}
+
+	if constructor, ok := hashConstructors[name]; ok {
+		return text(constructor().Size()*2, false, func(meta Metadata) string { return meta.Hashes[name] }), nil
+	}
+	if strings.HasPrefix(name, "exif.") {
+		tag := strings.TrimPrefix(name, "exif.")
+		for _, known := range exifTagNames {
+			if known == tag {
+				return text(20, true, func(meta Metadata) string { return meta.Exif[tag] }), nil
+			}
+		}
+		return column{}, fmt.Errorf("unknown EXIF tag '%s'", tag)
+	}
+	return column{}, fmt.Errorf("unknown column '%s'", name)
+}
+
+// Digests which the listing shows as columns
+func columnHashes(columns []column) []string {
+	var names []string
+	for _, c := range columns {
+		if hashConstructors[c.name] != nil {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// Shrink the flexible columns until a row fits in width, the last column
+// is left lastColumnWidth of room. Nothing changes when it already fits.
+func fitColumns(columns []column, width int) {
+	total := lastColumnWidth
+	for _, c := range columns[:len(columns)-1] {
+		total += c.width + 1
+	}
+	for total > width {
+		widest := -1
+		for i, c := range columns[:len(columns)-1] {
+			if c.flex && c.width > minColumnWidth && (widest < 0 || c.width > columns[widest].width) {
+				widest = i
+			}
+		}
+		if widest < 0 {
+			return
+		}
+		columns[widest].width--
+		total--
+	}
+}
+
+// Size the listing to the terminal, when stdout is one
+func fitColumnsToTerminal(columns []column) {
+	if cols, _, err := terminalSize(int(os.Stdout.Fd())); err == nil && cols > 0 {
+		fitColumns(columns, cols)
+	}
+}
+
+// Modification time in the --time-format layout
+func formatModTime(meta Metadata, info os.FileInfo) string {
+	if meta.ModTime != "" && (timeFormat == "" || info == nil) {
+		return meta.ModTime
+	}
+	modTime := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	if info != nil {
+		modTime = info.ModTime()
+	}
+
+	switch timeFormat {
+	case "", "default":
+		return modTime.Format(timeFormats["default"])
+	case "unix":
+		return fmt.Sprintf("%d", modTime.Unix())
+	case "relative":
+		return relativeTime(time.Since(modTime))
+	}
+	if layout, ok := timeFormats[timeFormat]; ok {
+		return modTime.Format(layout)
+	}
+	return modTime.Format(timeFormat)
+}
+
+// Utility function to describe how long ago something happened
+func relativeTime(age time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, unit := range units {
+		if n := int(age / unit.size); n > 0 {
+			if n == 1 {
+				return fmt.Sprintf("1 %s ago", unit.name)
+			}
+			return fmt.Sprintf("%d %ss ago", n, unit.name)
+		}
+	}
+	return "just now"
+}
+
+// Render one row of the listing. The last column is not padded.
+func renderRow(columns []column, meta Metadata, info os.FileInfo, directory string, filename string) string {
+	cells := make([]string, len(columns))
+	for i, c := range columns {
+		last := i == len(columns)-1
+
+		switch c.name {
+		case "name", "path":
+			name := filename
+			if c.name == "path" {
+				name = directory + "/" + filename
+			}
+			cells[i] = colorName(name, meta, info, c.width, last)
+			continue
+		}
+
+		value := c.value(meta, info)
+		switch {
+		case last:
+		case c.right:
+			value = fmt.Sprintf("%*s", c.width, value)
+		default:
+			value = formatColumn(value, c.width)
+		}
+		if c.name == "mode" {
+			if meta.Suid {
+				value = colorize("su", value)
+			} else if meta.Capabilities != "" {
+				// File capabilities grant privileges much like SUID does
+				value = colorize("ca", value)
+			}
+		}
+		cells[i] = value
+	}
+	return strings.Join(cells, " ")
+}
+
+// Color a file name by its type and permissions, padding it unless it is
+// the last column
+func colorName(name string, meta Metadata, info os.FileInfo, width int, last bool) string {
+	if meta.Link != "" {
+		// file is a link, color it light yellow
+		if last {
+			return colorize("ln", name) + " --> " + meta.Link
+		}
+		return colorize("ln", formatColumn(name+" --> "+meta.Link, width))
+	}
+	if !last {
+		name = formatColumn(name, width)
+	}
+
+	color := "fi"
+	if info != nil && info.Mode().Perm()&0111 != 0 {
+		if info.Mode().Perm()&0007 != 0 {
+			// file is world executable, color it dark red
+			color = "ew"
+		} else if info.Mode().Perm()&0070 != 0 {
+			// file is group executable, color it light red
+			color = "eg"
+		} else {
+			// file is owner executable, color it light pink
+			color = "ex"
+		}
+	}
+	return colorize(color, name)
+}
+
+// Digests not already shown as columns, printed below the row
+func hashesOutsideColumns(hashes map[string]string, columns []column) map[string]string {
+	rest := make(map[string]string)
+	for name, sum := range hashes {
+		rest[name] = sum
+	}
+	for _, name := range columnHashes(columns) {
+		delete(rest, name)
+	}
+	return rest
+}
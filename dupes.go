@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Size of the blocks read from each end of a file for the partial hash
+const dupeBlockSize = 4096
+
+// dupeFile is a candidate for duplicate detection
+type dupeFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	dev     uint64
+	ino     uint64
+}
+
+// DupeSet is a group of files with identical content, the first one is kept
+type DupeSet struct {
+	Size       int64
+	Wasted     int64
+	Keep       string
+	Duplicates []string
+}
+
+// Make a duplicate candidate from a result, symlinks and empty files are not
+func newDupeFile(result Result) (dupeFile, bool) {
+	if result.info == nil || !result.info.Mode().IsRegular() || result.info.Size() == 0 {
+		return dupeFile{}, false
+	}
+	file := dupeFile{path: result.Path, size: result.info.Size(), modTime: result.info.ModTime()}
+	if stat, ok := result.info.Sys().(*syscall.Stat_t); ok {
+		file.dev, file.ino = uint64(stat.Dev), uint64(stat.Ino)
+	}
+	return file, true
+}
+
+// Group files with identical content by comparing their sizes, then a hash
+// of their first and last blocks and finally a full SHA-256. Hard links to
+// the same inode only count once as they take no extra space.
+func findDuplicates(files []dupeFile) [][]dupeFile {
+	bySize := make(map[int64][]dupeFile)
+	for _, file := range files {
+		bySize[file.size] = append(bySize[file.size], file)
+	}
+
+	var sets [][]dupeFile
+	for size, group := range bySize {
+		group = uniqueInodes(group)
+		if len(group) < 2 {
+			continue
+		}
+		for _, partial := range groupByHash(group, partialHash) {
+			// Small files were hashed completely by the partial hash
+			if size <= 2*dupeBlockSize {
+				sets = append(sets, partial)
+				continue
+			}
+			sets = append(sets, groupByHash(partial, fullHash)...)
+		}
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i][0].size != sets[j][0].size {
+			return sets[i][0].size > sets[j][0].size
+		}
+		return sets[i][0].path < sets[j][0].path
+	})
+	return sets
+}
+
+// Drop files which are hard links to an inode already in the group
+func uniqueInodes(group []dupeFile) []dupeFile {
+	seen := make(map[[2]uint64]bool)
+	var unique []dupeFile
+	for _, file := range group {
+		key := [2]uint64{file.dev, file.ino}
+		if file.ino != 0 && seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, file)
+	}
+	return unique
+}
+
+// Split a group by a hash of each file, keeping only groups of two or more
+func groupByHash(group []dupeFile, hash func(dupeFile) ([]byte, error)) [][]dupeFile {
+	byHash := make(map[string][]dupeFile)
+	var order []string
+	for _, file := range group {
+		sum, err := hash(file)
+		if err != nil {
+			continue
+		}
+		key := string(sum)
+		if _, ok := byHash[key]; !ok {
+			order = append(order, key)
+		}
+		byHash[key] = append(byHash[key], file)
+	}
+
+	var groups [][]dupeFile
+	for _, key := range order {
+		if len(byHash[key]) > 1 {
+			groups = append(groups, byHash[key])
+		}
+	}
+	return groups
+}
+
+// Hash the first and last blocks of a file
+func partialHash(file dupeFile) ([]byte, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, dupeBlockSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if file.size > dupeBlockSize {
+		offset := file.size - dupeBlockSize
+		if offset < dupeBlockSize {
+			offset = dupeBlockSize
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// Hash the whole content of a file
+func fullHash(file dupeFile) ([]byte, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Move the file to keep to the front of the set
+func chooseKeeper(set []dupeFile, keep string) {
+	best := 0
+	for i, file := range set {
+		switch keep {
+		case "oldest":
+			if file.modTime.Before(set[best].modTime) {
+				best = i
+			}
+		case "shortest":
+			if len(file.path) < len(set[best].path) {
+				best = i
+			}
+		}
+	}
+	set[0], set[best] = set[best], set[0]
+}
+
+// Replace a duplicate with a hard link to the kept file, atomically
+func hardlinkDuplicate(keep string, duplicate string) error {
+	tmp := filepath.Join(filepath.Dir(duplicate), fmt.Sprintf(".%s.ffs-%d", filepath.Base(duplicate), os.Getpid()))
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, duplicate); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Check a duplicate still has the content of the kept file before removing it
+func sameContent(keep string, duplicate string) bool {
+	a, errA := fullHash(dupeFile{path: keep})
+	b, errB := fullHash(dupeFile{path: duplicate})
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// Print the duplicate sets and apply the action to all but the kept file of
+// each. Nothing is changed unless write is set. Returns the number of files
+// in duplicate sets and the bytes wasted by the duplicates.
+func reportDuplicates(files []dupeFile, keep string, action string, write bool, format string, errors bool) (int, int64) {
+	sets := findDuplicates(files)
+
+	label := map[string]string{"hardlink": "link", "delete": "delete"}[action]
+	if label == "" {
+		label = "dupe"
+	} else if !write {
+		label = "would " + label
+	}
+
+	// The labels line up whatever the action is
+	width := len(label)
+	if width < len("keep") {
+		width = len("keep")
+	}
+
+	count := 0
+	var wasted int64
+	for _, set := range sets {
+		chooseKeeper(set, keep)
+		size := set[0].size
+		count += len(set)
+		wasted += size * int64(len(set)-1)
+
+		if format == "json" {
+			dupeSet := DupeSet{Size: size, Wasted: size * int64(len(set)-1), Keep: set[0].path}
+			for _, file := range set[1:] {
+				dupeSet.Duplicates = append(dupeSet.Duplicates, file.path)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(dupeSet)
+		} else {
+			fmt.Printf("\n%s (%s wasted):\n", colorize("hd", fmt.Sprintf("%d files of %s", len(set), humanizeBytes(size))), colorize("hi", humanizeBytes(size*int64(len(set)-1))))
+			fmt.Printf("  %s %s\n", colorize("ad", fmt.Sprintf("%-*s", width, "keep")), set[0].path)
+		}
+
+		for _, file := range set[1:] {
+			if format != "json" {
+				fmt.Printf("  %s %s\n", colorize("rm", fmt.Sprintf("%-*s", width, label)), file.path)
+			}
+			if !write || action == "" {
+				continue
+			}
+
+			var err error
+			if !sameContent(set[0].path, file.path) {
+				err = fmt.Errorf("content changed since it was hashed")
+			} else if action == "hardlink" {
+				err = hardlinkDuplicate(set[0].path, file.path)
+			} else if action == "delete" {
+				err = os.Remove(file.path)
+			}
+			if err != nil && errors {
+				fmt.Printf("Error processing duplicate %s: %v\n", file.path, err)
+			}
+		}
+	}
+
+	if format != "json" {
+		fmt.Printf("\n%s %d\n", colorize("hd", "- duplicate sets:"), len(sets))
+		fmt.Printf("%s %d\n", colorize("hd", "- duplicate files:"), count-len(sets))
+		fmt.Printf("%s %d (%s)\n\n", colorize("hd", "- wasted:"), wasted, colorize("hi", humanizeBytes(wasted)))
+	}
+
+	return count, wasted
+}
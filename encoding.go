@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Number of leading bytes examined when guessing the text encoding
+const encodingSampleSize = 4096
+
+// Canonical names for the encodings we know how to transcode
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingLatin1  = "latin1"
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// Utility function to map user supplied encoding names onto canonical ones
+func normalizeEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return "auto", nil
+	case "utf-8", "utf8":
+		return encodingUTF8, nil
+	case "utf-16le", "utf16le", "utf-16", "utf16":
+		return encodingUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return encodingUTF16BE, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return encodingLatin1, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", name)
+}
+
+// Guess the encoding of a buffer from its byte order mark, falling back to
+// NUL byte distribution and UTF-8 validity. Returns an empty string for data
+// which does not look like text in any supported encoding.
+func detectEncoding(buf []byte) string {
+	switch {
+	case bytes.HasPrefix(buf, bomUTF8):
+		return encodingUTF8
+	case bytes.HasPrefix(buf, bomUTF16LE):
+		return encodingUTF16LE
+	case bytes.HasPrefix(buf, bomUTF16BE):
+		return encodingUTF16BE
+	}
+
+	sample := buf
+	if len(sample) > encodingSampleSize {
+		sample = sample[:encodingSampleSize]
+	}
+	if len(sample) == 0 {
+		return encodingUTF8
+	}
+
+	// ASCII text encoded as UTF-16 has a NUL in every other byte
+	var evenNuls, oddNuls int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNuls++
+		} else {
+			oddNuls++
+		}
+	}
+	// Only samples of four characters or more are judged. In shorter ones a
+	// single NUL already passes for every other byte, so a binary header
+	// such as ELF\x00\x01 would be taken for UTF-16 text.
+	pairs := len(sample) / 2
+	if pairs >= 4 {
+		if oddNuls*10 >= pairs*4 && evenNuls*10 < pairs {
+			return encodingUTF16LE
+		}
+		if evenNuls*10 >= pairs*4 && oddNuls*10 < pairs {
+			return encodingUTF16BE
+		}
+	}
+	if evenNuls+oddNuls > 0 {
+		return ""
+	}
+
+	// Allow a multi-byte sequence to be cut off at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			return encodingUTF8
+		}
+		if len(buf) == len(sample) {
+			break
+		}
+		sample = sample[:len(sample)-1]
+	}
+
+	return encodingLatin1
+}
+
+// decoder transcodes a byte stream in a supported encoding into UTF-8
+type decoder struct {
+	r        *bufio.Reader
+	encoding string
+	pending  []byte
+	held     rune
+	hasHeld  bool
+}
+
+// Wrap a reader so that it yields UTF-8. Unknown encodings and UTF-8 itself
+// are passed through, minus any byte order mark.
+func newDecoder(r io.Reader, encoding string) io.Reader {
+	br := bufio.NewReader(r)
+
+	// Skip the byte order mark so it never shows up in matched lines
+	var bom []byte
+	switch encoding {
+	case encodingUTF8, "":
+		bom = bomUTF8
+	case encodingUTF16LE:
+		bom = bomUTF16LE
+	case encodingUTF16BE:
+		bom = bomUTF16BE
+	}
+	if peek, err := br.Peek(len(bom)); err == nil && bytes.Equal(peek, bom) {
+		br.Discard(len(bom))
+	}
+
+	if encoding != encodingUTF16LE && encoding != encodingUTF16BE && encoding != encodingLatin1 {
+		return br
+	}
+	return &decoder{r: br, encoding: encoding}
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+
+	var buf [utf8.UTFMax]byte
+	for n < len(p) {
+		r, err := d.next()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		size := utf8.EncodeRune(buf[:], r)
+		copied := copy(p[n:], buf[:size])
+		n += copied
+		if copied < size {
+			d.pending = append(d.pending, buf[copied:size]...)
+		}
+	}
+	return n, nil
+}
+
+// Decode the next rune from the underlying stream
+func (d *decoder) next() (rune, error) {
+	if d.encoding == encodingLatin1 {
+		b, err := d.r.ReadByte()
+		return rune(b), err
+	}
+
+	var u1 rune
+	if d.hasHeld {
+		u1, d.hasHeld = d.held, false
+	} else {
+		unit, err := d.unit()
+		if err != nil {
+			return 0, err
+		}
+		u1 = unit
+	}
+	if !utf16.IsSurrogate(u1) {
+		return u1, nil
+	}
+
+	u2, err := d.unit()
+	if err != nil {
+		if err == io.EOF {
+			return utf8.RuneError, nil
+		}
+		return 0, err
+	}
+	if r := utf16.DecodeRune(u1, u2); r != utf8.RuneError {
+		return r, nil
+	}
+
+	// Unpaired surrogate, keep the second unit for the next call
+	d.held, d.hasHeld = u2, true
+	return utf8.RuneError, nil
+}
+
+// Read a single UTF-16 code unit in the configured byte order
+func (d *decoder) unit() (rune, error) {
+	b1, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b2, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return utf8.RuneError, nil
+		}
+		return 0, err
+	}
+	if d.encoding == encodingUTF16BE {
+		return rune(b1)<<8 | rune(b2), nil
+	}
+	return rune(b2)<<8 | rune(b1), nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+)
+
+// Total size of the arguments passed to one batched command. Kept well below
+// ARG_MAX, as xargs does, to leave room for the environment.
+const execArgMax = 128 * 1024
+
+// Placeholders substituted into --exec arguments
+const (
+	placeholderPath     = "{}"
+	placeholderDir      = "{//}"
+	placeholderBase     = "{/}"
+	placeholderLine     = "{line}"
+	execTerminatorEach  = ";"
+	execTerminatorBatch = "+"
+)
+
+// execAction runs a command for every matched file, or for batches of them
+type execAction struct {
+	args  []string
+	batch bool
+
+	queue   chan []string
+	wg      sync.WaitGroup
+	pending []string
+	size    int
+	fixed   int
+
+	mu     sync.Mutex
+	status int
+}
+
+// Pull a find style --exec CMD ARGS... ; or --exec CMD ARGS... + action out
+// of the command line, as pflag cannot parse a variable number of values.
+// Returns the remaining arguments, the command and whether it is batched.
+// The flags tell which arguments are values, so that -s --exec searches for
+// "--exec" rather than starting a command.
+func extractExecArgs(args []string, flags *pflag.FlagSet) ([]string, []string, bool, error) {
+	var rest, command []string
+	batch := false
+	found := false
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if takesValue(flags, args[i]) && i+1 < len(args) {
+			rest = append(rest, args[i], args[i+1])
+			i++
+			continue
+		}
+		if args[i] != "--exec" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if found {
+			return nil, nil, false, fmt.Errorf("--exec may only be given once")
+		}
+		found = true
+
+		end := i + 1
+		for end < len(args) && args[end] != execTerminatorEach && args[end] != execTerminatorBatch {
+			end++
+		}
+		if end == len(args) {
+			return nil, nil, false, fmt.Errorf("--exec must be terminated by ';' or '+'")
+		}
+		command = args[i+1 : end]
+		batch = args[end] == execTerminatorBatch
+		if len(command) == 0 {
+			return nil, nil, false, fmt.Errorf("--exec requires a command")
+		}
+		i = end
+	}
+
+	// As with find, a batch appends the paths where a lone {} ends the command
+	if batch {
+		if command[len(command)-1] != placeholderPath {
+			return nil, nil, false, fmt.Errorf("a batched --exec must end with %s before '+'", placeholderPath)
+		}
+		for _, arg := range command[:len(command)-1] {
+			for _, placeholder := range []string{placeholderPath, placeholderDir, placeholderBase, placeholderLine} {
+				if strings.Contains(arg, placeholder) {
+					return nil, nil, false, fmt.Errorf("%s can only be the last argument of a batched --exec", placeholder)
+				}
+			}
+		}
+	}
+
+	return rest, command, batch, nil
+}
+
+// Whether arg is a flag whose value is the next argument, such as --string
+// or -s, or -is where the last of several short flags takes a value
+func takesValue(flags *pflag.FlagSet, arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		if strings.Contains(arg, "=") {
+			return false
+		}
+		flag := flags.Lookup(arg[2:])
+		return flag != nil && flag.NoOptDefVal == ""
+	}
+	if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+		return false
+	}
+	for i := 1; i < len(arg); i++ {
+		if arg[i] >= 0x80 {
+			return false
+		}
+		flag := flags.ShorthandLookup(arg[i : i+1])
+		if flag == nil {
+			return false
+		}
+		// The rest of the argument is the value when there is any
+		if flag.NoOptDefVal == "" {
+			return i == len(arg)-1
+		}
+	}
+	return false
+}
+
+// Start the workers which run the command, at most jobs at a time
+func newExecAction(args []string, batch bool, jobs int) *execAction {
+	if jobs < 1 {
+		jobs = 1
+	}
+	action := &execAction{args: args, batch: batch, queue: make(chan []string)}
+	// The arguments before the final {} go into every batch
+	if batch {
+		for _, arg := range args[:len(args)-1] {
+			action.fixed += len(arg) + 1
+		}
+	}
+	for i := 0; i < jobs; i++ {
+		action.wg.Add(1)
+		go func() {
+			defer action.wg.Done()
+			for argv := range action.queue {
+				action.run(argv)
+			}
+		}()
+	}
+	return action
+}
+
+// Queue the command for a matched file, line is the first matching line or 0
+func (action *execAction) add(path string, line int) {
+	if !action.batch {
+		action.queue <- action.expand(path, line)
+		return
+	}
+
+	// Start a new batch when this path would take the arguments over the limit
+	if len(action.pending) > 0 && action.fixed+action.size+len(path)+1 > execArgMax {
+		action.flush()
+	}
+	action.pending = append(action.pending, path)
+	action.size += len(path) + 1
+}
+
+// Substitute the placeholders of a per file command
+func (action *execAction) expand(path string, line int) []string {
+	replacer := strings.NewReplacer(
+		placeholderDir, filepath.Dir(path),
+		placeholderBase, filepath.Base(path),
+		placeholderLine, strconv.Itoa(line),
+		placeholderPath, path,
+	)
+	argv := make([]string, len(action.args))
+	for i, arg := range action.args {
+		argv[i] = replacer.Replace(arg)
+	}
+	return argv
+}
+
+// Run the command for the pending batch, the paths taking the place of the final {}
+func (action *execAction) flush() {
+	if len(action.pending) == 0 {
+		return
+	}
+
+	argv := append(append([]string{}, action.args[:len(action.args)-1]...), action.pending...)
+	action.pending = nil
+	action.size = 0
+	action.queue <- argv
+}
+
+// Run one command, recording its exit status
+func (action *execAction) run(argv []string) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	status := 0
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			status = exitErr.ExitCode()
+		} else if ok {
+			// Killed by a signal
+			status = 1
+		} else {
+			fmt.Fprintf(os.Stderr, "Error running %s: %v\n", argv[0], err)
+			status = 127
+		}
+	}
+
+	action.mu.Lock()
+	if status > action.status {
+		action.status = status
+	}
+	action.mu.Unlock()
+}
+
+// Run any remaining batch, wait for all commands and return the highest exit status
+func (action *execAction) wait() int {
+	if action.batch {
+		action.flush()
+	}
+	close(action.queue)
+	action.wg.Wait()
+	return action.status
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Names of the EXIF tags kept from images
+var exifTagNames = map[uint16]string{
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8827: "ISO",
+	0x9003: "DateTimeOriginal",
+	0x920a: "FocalLength",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa434: "LensModel",
+}
+
+// Tag of IFD0 pointing at the Exif sub-IFD
+const exifIFDPointer = 0x8769
+
+// Parse the EXIF tags of a JPEG or TIFF image from its leading bytes.
+// Returns nil when there are none.
+func parseExif(buf []byte) map[string]string {
+	tiff := buf
+	if bytes.HasPrefix(buf, []byte{0xff, 0xd8}) {
+		tiff = findExifSegment(buf)
+	}
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	tags := make(map[string]string)
+	parseIFD(tiff, order, order.Uint32(tiff[4:]), tags, 0)
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// Find the TIFF data of the APP1 Exif segment of a JPEG
+func findExifSegment(buf []byte) []byte {
+	for offset := 2; offset+4 <= len(buf); {
+		if buf[offset] != 0xff {
+			return nil
+		}
+		marker := buf[offset+1]
+		length := int(binary.BigEndian.Uint16(buf[offset+2:]))
+		if marker == 0xda || length < 2 {
+			// Image data starts, there are no more metadata segments
+			return nil
+		}
+		segment := buf[offset+4 : minInt(offset+2+length, len(buf))]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		offset += 2 + length
+	}
+	return nil
+}
+
+// Read the known tags of an image file directory, following the pointer
+// to the Exif sub-IFD
+func parseIFD(tiff []byte, order binary.ByteOrder, offset uint32, tags map[string]string, depth int) {
+	if depth > 1 || int64(offset)+2 > int64(len(tiff)) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		tag := order.Uint16(tiff[entry:])
+		kind := order.Uint16(tiff[entry+2:])
+		n := order.Uint32(tiff[entry+4:])
+
+		if tag == exifIFDPointer {
+			parseIFD(tiff, order, order.Uint32(tiff[entry+8:]), tags, depth+1)
+			continue
+		}
+		name, ok := exifTagNames[tag]
+		if !ok {
+			continue
+		}
+		if value := exifValue(tiff, order, kind, n, tiff[entry+8:entry+12]); value != "" {
+			tags[name] = value
+		}
+	}
+}
+
+// Format the first value of a tag, or all of it for text
+func exifValue(tiff []byte, order binary.ByteOrder, kind uint16, count uint32, inline []byte) string {
+	sizes := map[uint16]uint32{2: 1, 3: 2, 4: 4, 5: 8, 9: 4, 10: 8}
+	size, ok := sizes[kind]
+	if !ok || count == 0 || count > 1<<16 {
+		return ""
+	}
+
+	data := inline
+	if size*count > 4 {
+		offset := order.Uint32(inline)
+		if int64(offset)+int64(size*count) > int64(len(tiff)) {
+			return ""
+		}
+		data = tiff[offset : offset+size*count]
+	}
+
+	switch kind {
+	case 2:
+		return string(bytes.TrimRight(data[:count], "\x00 "))
+	case 3:
+		return fmt.Sprintf("%d", order.Uint16(data))
+	case 4:
+		return fmt.Sprintf("%d", order.Uint32(data))
+	case 9:
+		return fmt.Sprintf("%d", int32(order.Uint32(data)))
+	default:
+		num, den := int64(order.Uint32(data)), int64(order.Uint32(data[4:]))
+		if kind == 10 {
+			num, den = int64(int32(num)), int64(int32(den))
+		}
+		return formatRational(num, den)
+	}
+}
+
+// Utility function to format a rational as a whole number, a fraction
+// below one like an exposure time, or a decimal
+func formatRational(num int64, den int64) string {
+	if den == 0 {
+		return ""
+	}
+	a, b := num, den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	if a < 0 {
+		a = -a
+	}
+	if a > 0 {
+		num, den = num/a, den/a
+	}
+	switch {
+	case den == 1:
+		return fmt.Sprintf("%d", num)
+	case num < den:
+		return fmt.Sprintf("%d/%d", num, den)
+	default:
+		return fmt.Sprintf("%.1f", float64(num)/float64(den))
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -5,11 +5,14 @@ import _ "net/http/pprof"
 import (
 	"bufio"
 	"fmt"
+	"io"
 
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,16 +21,25 @@ import (
 )
 
 type Metadata struct {
-	Size     int64
-	Mode     string
-	Suid     bool
-	Link     string
-	Owner    string
-	Group    string
-	ModTime  string
-	MimeType string
-	ExifData string
-	Error    string
+	Size         int64
+	Mode         string
+	Suid         bool
+	Link         string
+	Owner        string
+	Group        string
+	ModTime      string
+	MimeType     string
+	Description  string
+	Encoding     string
+	Kind         string
+	ExifData     string
+	Exif         map[string]string `json:",omitempty"`
+	Exe          *ExeInfo          `json:",omitempty"`
+	Xattrs       []string          `json:",omitempty"`
+	ACL          string            `json:",omitempty"`
+	Capabilities string            `json:",omitempty"`
+	Hashes       map[string]string `json:",omitempty"`
+	Error        string
 }
 
 const (
@@ -41,15 +53,327 @@ const (
 	truncateLength = 3
 )
 
+// Name reported for matches in piped input
+const stdinName = "(standard input)"
+
 var lastDir string
 var fileCount int
 var matchCount int
 var byteCount int64
 var metadataString string
+var textEncoding string
+var binaryMatches bool
+var magicFile string
+var outputFormat string
+var replaceTemplate string
+var replaceMode bool
+var writeFiles bool
+var execArgs []string
+var execBatch bool
+var execJobs int
+var print0 bool
+var filesFrom string
+var globOperands bool
+var operandPaths []string
+var searchStdin bool
+var dupesMode bool
+var dupesKeep string
+var dupesAction string
+var hashAlgorithms []string
+var hashList map[string]bool
+var noIndex bool
+var useCache bool
+var watchMode bool
+var interactive bool
+var sortKey string
+var reverseSort bool
+var topResults int
+var statsMode bool
+var vimgrepMode bool
 
 func main() {
+	// ffs index build|update [ROOT] maintains the index used to narrow searches
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		runIndexCommand(os.Args[2:])
+		return
+	}
+
 	verbose, binary, errors, links, root, depth, filePatternRegex, stringPatternRegex, hexPatternRegex, metaPatternRegex, globalPattern, ignoreParser, tree := parseFlags()
 
+	// Directory the depth limit is measured from
+	walkRoot := root
+
+	// Run the --exec command for matched files
+	var action *execAction
+	if len(execArgs) > 0 {
+		action = newExecAction(execArgs, execBatch, execJobs)
+	}
+
+	// Candidates for duplicate detection, compared once everything is walked
+	var dupeCandidates []dupeFile
+
+	// Watch the searched directories, remembering the matches of each file
+	var w *watcher
+	watchState := make(map[string][]Match)
+	if watchMode {
+		var err error
+		if w, err = newWatcher(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Collects results instead of reporting them while changes are searched
+	var observe func(result Result)
+
+	// Breakdowns of the reported files for --stats
+	var stats *statsCollector
+	if statsMode {
+		stats = newStatsCollector()
+	}
+
+	// Results held back until the search is done to be sorted
+	var sortBuffer []Result
+	buffering := sortKey != "" || topResults > 0
+
+	// Results kept to browse or print as a tree once the search is done
+	var browseResults []Result
+	var treeResults []Result
+
+	// Results of --format sarif and html, printed as one document at the end
+	var reportResults []Result
+
+	// Count a reported file, and its size unless it is a symbolic link, for
+	// the output modes which do not print through printResults
+	countResult := func(result Result) {
+		fileCount++
+		if result.Meta.Link == "" {
+			byteCount += result.Meta.Size
+		}
+	}
+
+	// Report a file in the selected output format
+	report := func(result Result) {
+		if interactive {
+			browseResults = append(browseResults, result)
+			return
+		}
+		if dupesMode {
+			if file, ok := newDupeFile(result); ok {
+				dupeCandidates = append(dupeCandidates, file)
+			}
+			return
+		}
+
+		if action != nil {
+			line := 0
+			if len(result.Matches) > 0 {
+				line = result.Matches[0].Line
+			}
+			action.add(result.Path, line)
+		}
+
+		if tree && outputFormat == "text" {
+			treeResults = append(treeResults, result)
+			countResult(result)
+			return
+		}
+
+		directory, filename := filepath.Split(result.Path)
+		directory = strings.TrimSuffix(directory, string(os.PathSeparator))
+		if directory == "" {
+			directory = root
+		}
+
+		// Only report that a binary file matched rather than print its lines
+		quietBinary := binaryMatches && result.Meta.Kind == kindBinary
+
+		if outputFormat == "vimgrep" {
+			printVimgrep(result, stringPatternRegex, quietBinary)
+			countResult(result)
+			return
+		}
+
+		if outputFormat == "sarif" || outputFormat == "html" {
+			reportResults = append(reportResults, result)
+			countResult(result)
+			return
+		}
+
+		if outputFormat != "text" {
+			if quietBinary {
+				for i := range result.Matches {
+					result.Matches[i].Text = ""
+				}
+			}
+			if outputTemplate != nil {
+				printTemplate(result)
+			} else {
+				printJSON(result)
+			}
+			countResult(result)
+			return
+		}
+
+		if result.info != nil {
+			lastDir, fileCount, matchCount, byteCount = printResults(fileCount, lastDir, directory, filename, result.Meta, result.info, byteCount, matchCount, verbose, errors)
+		} else {
+			// Streams have no file details, only name them
+			countResult(result)
+			if !verbose && print0 {
+				fmt.Printf("%s\x00", result.Path)
+			} else if !verbose {
+				fmt.Println(result.Path)
+			}
+		}
+		if !verbose {
+			return
+		}
+		if quietBinary {
+			if len(result.Matches) > 0 {
+				fmt.Printf("Binary file %s matches\n", colorize("pa", result.Path))
+			}
+			return
+		}
+		for _, match := range result.Matches {
+			printMatchLine(result.Path, match)
+		}
+	}
+
+	// Take a file which passed all filters. The statistics and watch state
+	// count every file, before the results are sorted and cut to --top.
+	emit := func(result Result) {
+		if observe != nil {
+			observe(result)
+			return
+		}
+		if stats != nil {
+			stats.add(result)
+		}
+		if w != nil {
+			watchState[result.Path] = result.Matches
+		}
+		if buffering {
+			sortBuffer = append(sortBuffer, result)
+			if topResults > 0 && len(sortBuffer) >= 2*topResults {
+				sortBuffer = trimResults(sortBuffer, sortKey, reverseSort, topResults)
+			}
+			return
+		}
+		report(result)
+	}
+
+	// Rewrite matched lines using the replacement template, printing a diff
+	replaceMatches := func(result Result) {
+		if result.Meta.Kind == kindBinary || (result.Meta.Encoding != encodingUTF8 && result.Meta.Encoding != "") || (textEncoding != "auto" && textEncoding != encodingUTF8) {
+			if errors {
+				fmt.Printf("Error replacing in file %s: only UTF-8 text files can be rewritten\n", result.Path)
+			}
+			return
+		}
+
+		var out io.Writer
+		if outputFormat == "text" {
+			out = os.Stdout
+		}
+		if _, err := replaceInFile(result.Path, stringPatternRegex, replaceTemplate, writeFiles, out); err != nil {
+			if errors {
+				fmt.Printf("Error replacing in file %s: %v\n", result.Path, err)
+			}
+		}
+	}
+
+	// Scan each line of a stream for the content pattern, collecting matches
+	scanContent := func(reader io.Reader, result *Result) error {
+		if hexPatternRegex == nil {
+			// Transcode to UTF-8 so string patterns match regardless of encoding
+			enc := textEncoding
+			if enc == "auto" {
+				enc = result.Meta.Encoding
+			}
+			reader = newDecoder(reader, enc)
+		}
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // set buffer size to 1MB
+		lineNumber := 1
+		for scanner.Scan() {
+			line := scanner.Text()
+			var match bool
+			column := 0
+			if hexPatternRegex != nil {
+				// Convert line to hex string and perform match on hex string
+				hex := ""
+				for _, b := range line {
+					hex += " " + strconv.FormatInt(int64(b), 16)
+				}
+				match = hexPatternRegex.MatchString(hex)
+			} else {
+				column = matchColumn(stringPatternRegex, line)
+				match = column > 0
+			}
+			if match {
+				matchCount++
+				result.Matches = append(result.Matches, Match{Line: lineNumber, Column: column, Text: line})
+			}
+			lineNumber++
+		}
+		return scanner.Err()
+	}
+
+	// Search a stream such as standard input, which has no file details
+	searchStream := func(name string, r io.Reader) {
+		// Hash everything read from the stream, as it cannot be read twice
+		var hasher *multiHash
+		if len(hashAlgorithms) > 0 {
+			hasher = newMultiHash(hashAlgorithms)
+			r = io.TeeReader(r, hasher)
+		}
+		reader := bufio.NewReaderSize(r, classifySampleSize)
+		sample, _ := reader.Peek(classifySampleSize)
+
+		result := Result{Path: name}
+		result.Meta.MimeType = http.DetectContentType(sample)
+		result.Meta.Encoding = detectEncoding(sample)
+		result.Meta.Kind = classifyContent(sample, result.Meta.MimeType, result.Meta.Encoding)
+		if !binary && !binaryMatches && result.Meta.Kind == kindBinary {
+			return
+		}
+
+		err := scanContent(reader, &result)
+		if hasher != nil {
+			io.Copy(io.Discard, reader)
+			result.Meta.Hashes = hasher.sums()
+			if hashList != nil && !matchHashList(result.Meta.Hashes, hashList) {
+				return
+			}
+		}
+		if len(result.Matches) > 0 {
+			emit(result)
+		}
+		if err != nil && errors {
+			fmt.Printf("Error scanning %s: %v\n", name, err)
+		}
+	}
+
+	// Narrow a content search of root to the files its index says may match
+	var index *indexFilter
+	if stringPatternRegex != nil && hexPatternRegex == nil && !noIndex && (textEncoding == "auto" || textEncoding == encodingUTF8) {
+		index = newIndexFilter(root, stringPatternRegex.String())
+	}
+
+	// Reuse the metadata of files unchanged since an earlier run, signatures
+	// from a --magic file would not be reflected in it
+	var cache *metadataCache
+	if useCache && magicFile == "" {
+		var err error
+		if cache, err = openMetadataCache(); err != nil && errors {
+			fmt.Printf("Error opening metadata cache: %v\n", err)
+		}
+	}
+
+	// Files named explicitly are searched even when .gitignore excludes them
+	explicitFile := ""
+
 	search := func(path string, info os.FileInfo, err error) error {
 		var lastCount = matchCount
 
@@ -62,7 +386,7 @@ func main() {
 
 		// Check if file is a directory and if depth is reached
 		if info.IsDir() {
-			relPath, err := filepath.Rel(root, path)
+			relPath, err := filepath.Rel(walkRoot, path)
 			if err != nil {
 				if errors {
 					fmt.Printf("Error getting relative path for directory %s: %v\n", path, err)
@@ -72,22 +396,21 @@ func main() {
 			if depth >= 0 && strings.Count(relPath, string(os.PathSeparator)) >= depth && relPath != "." {
 				return filepath.SkipDir
 			}
+			if w != nil && (globalPattern || filepath.Base(path) != ".git") {
+				if err := w.add(path, true); err != nil && errors {
+					fmt.Printf("Error watching directory %s: %v\n", path, err)
+				}
+			}
 			return nil
 		}
 
 		// By default only search files according to .gitignore
-		if !globalPattern && (ignoreParser != nil && ignoreParser.MatchesPath(path)) {
+		if !globalPattern && path != explicitFile && (ignoreParser != nil && ignoreParser.MatchesPath(path)) {
 			return nil
 		}
 
-		directory, filename := filepath.Split(path)
-		directory = strings.TrimSuffix(directory, string(os.PathSeparator))
-		if directory == "" {
-			directory = root
-		}
-
 		// Ignore .git folders by default
-		if !globalPattern && strings.Contains(path, ".git") {
+		if !globalPattern && path != explicitFile && strings.Contains(path, ".git") {
 			return nil
 		}
 
@@ -96,6 +419,11 @@ func main() {
 			return nil
 		}
 
+		// Skip files which the index shows cannot contain the string pattern
+		if index != nil && walkRoot == root && index.excludes(path, info) {
+			return nil
+		}
+
 		// Open the file for reading
 		file, err := os.Open(path)
 		if err != nil {
@@ -106,36 +434,67 @@ func main() {
 		}
 		defer file.Close()
 
-		if tree {
-			depth := strings.Count(directory, string(os.PathSeparator))
-			indent := strings.Repeat(" ", depth)
-			fmt.Println(indent + filepath.Base(directory) + "/")
+		if stats != nil {
+			stats.scanned(info.Size())
 		}
 
 		// Extract metadata and other file information
-		metaData, isBinary, err := extractFileData(file)
+		var metaData Metadata
+		var isBinary bool
+		if cache != nil {
+			metaData, isBinary, err = cache.extract(file, info)
+		} else {
+			metaData, isBinary, err = extractFileData(file, info)
+		}
 		if err != nil {
 			metaData.Error = fmt.Sprintf("Warn: %v", err)
 		}
 
+		// Hash up front when the digests are needed to filter the file, or
+		// there is no content scan to compute them alongside
+		skipBinary := !binary && !binaryMatches && isBinary
+		scanning := stringPatternRegex != nil || hexPatternRegex != nil
+		if len(hashAlgorithms) > 0 && !skipBinary && (hashList != nil || metaPatternRegex != nil || !scanning) {
+			file.Seek(0, 0)
+			metaData.Hashes, err = hashReader(file, hashAlgorithms)
+			if err != nil {
+				if errors {
+					fmt.Printf("Error hashing file %s: %v\n", path, err)
+				}
+				return nil
+			}
+		}
+
+		// Only keep files whose content is in the list of known hashes
+		if hashList != nil && !matchHashList(metaData.Hashes, hashList) {
+			return nil
+		}
+
 		// Check for metadata pattern match
 		if metaPatternRegex != nil {
-			metadataString = fmt.Sprintf("%d %s %s %s %s %s %s", metaData.Size, metaData.Mode, metaData.Owner, metaData.Group, metaData.ModTime, metaData.MimeType, metaData.ExifData)
+			metadataString = fmt.Sprintf("%d %s %s %s %s %s %s %s %s %s", metaData.Size, metaData.Mode, metaData.Owner, metaData.Group, metaData.ModTime, metaData.MimeType, metaData.Description, metaData.Encoding, metaData.Kind, metaData.ExifData)
+			if metaData.Exe != nil {
+				metadataString += " " + metaData.Exe.String()
+			}
+			if len(metaData.Xattrs) > 0 {
+				metadataString += " xattrs=" + strings.Join(metaData.Xattrs, ",")
+			}
+			if metaData.ACL != "" {
+				metadataString += " acl=" + metaData.ACL
+			}
+			if metaData.Capabilities != "" {
+				metadataString += " caps=" + metaData.Capabilities
+			}
+			if len(metaData.Hashes) > 0 {
+				metadataString += " " + strings.Join(formatHashes(metaData.Hashes), " ")
+			}
 			if metaPatternRegex.MatchString(metadataString) {
 				matchCount++
 			}
 		}
 
-		fi, err := os.Lstat(path)
-		if err != nil {
-			if errors {
-				log.Printf("Error Lstat-ing %s: %v\n", path, err)
-				return nil
-			}
-		}
-
-		// Add link pointer to metaData
-		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		// Add link pointer to metaData, the walker's details are from lstat
+		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
 			linkPath, err := filepath.EvalSymlinks(path)
 			if err != nil {
 				if errors {
@@ -147,44 +506,37 @@ func main() {
 		}
 
 		// Check if file is binary and skip if set to exclude binary files
-		if !binary && isBinary {
+		if skipBinary {
 			return nil
 		}
 
+		result := Result{Path: path, Meta: metaData, info: info}
+
 		// Scan each line of the file content
-		if stringPatternRegex != nil || hexPatternRegex != nil {
+		if scanning {
 			file.Seek(0, 0) // reset file pointer to the beginning of the file
-			scanner := bufio.NewScanner(file)
-			scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // set buffer size to 1MB
-			lineNumber := 1
-			hasPrintedFileDetails := false
-			for scanner.Scan() {
-				line := scanner.Text()
-				var match bool
-				if hexPatternRegex != nil {
-					// Convert line to hex string and perform match on hex string
-					hex := ""
-					for _, b := range line {
-						hex += " " + strconv.FormatInt(int64(b), 16)
-					}
-					match = hexPatternRegex.MatchString(hex)
-				} else {
-					match = stringPatternRegex.MatchString(line)
-				}
-				if match {
-					matchCount++
-					// Print results before printing the source line
-					if !hasPrintedFileDetails {
-						lastDir, fileCount, matchCount, byteCount = printResults(fileCount, lastDir, directory, filename, metaData, fi, byteCount, matchCount, verbose, tree, errors)
-						hasPrintedFileDetails = true
-					}
-					if verbose {
-						fmt.Printf("\x1b[38;5;221m%s\x1b[0m:\x1b[38;5;39m%d\x1b[0m:\x1b[38;5;8m%s\x1b[0m\n", path, lineNumber, replaceNonPrintable(line))
-					}
+
+			// Hash the content as it is scanned rather than reading it again
+			var reader io.Reader = file
+			var hasher *multiHash
+			if len(hashAlgorithms) > 0 && metaData.Hashes == nil {
+				hasher = newMultiHash(hashAlgorithms)
+				reader = io.TeeReader(file, hasher)
+			}
+			err := scanContent(reader, &result)
+			if hasher != nil {
+				// Hash whatever the scan left unread if it stopped early
+				io.Copy(hasher, file)
+				result.Meta.Hashes = hasher.sums()
+			}
+			// Print results followed by the matched source lines
+			if len(result.Matches) > 0 {
+				emit(result)
+				if replaceMode {
+					replaceMatches(result)
 				}
-				lineNumber++
 			}
-			if err := scanner.Err(); err != nil {
+			if err != nil {
 				if errors {
 					fmt.Printf("Error scanning file %s: %v\n", path, err)
 				}
@@ -193,15 +545,50 @@ func main() {
 		} else {
 			// Print results
 			if (matchCount > lastCount) || (stringPatternRegex == nil && hexPatternRegex == nil && metaPatternRegex == nil) {
-				lastDir, fileCount, matchCount, byteCount = printResults(fileCount, lastDir, directory, filename, metaData, fi, byteCount, matchCount, verbose, tree, errors)
+				emit(result)
 			}
 		}
 
 		return nil
 	}
 
-	// Search
-	err := Walk(root, links, search)
+	// Search piped input first, then the listed paths or everything below root
+	if searchStdin {
+		searchStream(stdinName, os.Stdin)
+	}
+
+	var err error
+	var searched []string
+	if filesFrom != "" || len(operandPaths) > 0 {
+		paths := operandPaths
+		if filesFrom != "" {
+			list, err := readPathList(filesFrom)
+			if err != nil {
+				fmt.Printf("Error reading file list: %v\n", err)
+				os.Exit(1)
+			}
+			paths = append(paths, list...)
+		}
+		for _, path := range paths {
+			walkRoot = path
+			explicitFile = ""
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				explicitFile = path
+				if w != nil {
+					if err := w.add(path, false); err != nil && errors {
+						fmt.Printf("Error watching file %s: %v\n", path, err)
+					}
+				}
+			}
+			if walkErr := Walk(path, links, search); walkErr != nil {
+				err = walkErr
+			}
+		}
+		searched = paths
+	} else if !searchStdin {
+		err = Walk(root, links, search)
+		searched = []string{root}
+	}
 
 	if err != nil {
 		if errors {
@@ -209,18 +596,120 @@ func main() {
 		}
 	}
 
-	if verbose {
-		fmt.Println("\n\x1b[36m- files:\x1b[0m", fileCount)
-		fmt.Printf("\x1b[36m- bytes:\x1b[0m %d (\x1b[33m%s\x1b[0m)\n", byteCount, humanizeBytes(byteCount))
+	if cache != nil {
+		cache.prune(searched)
+		if err := cache.save(); err != nil && errors {
+			fmt.Printf("Error saving metadata cache: %v\n", err)
+		}
+	}
+
+	// Report the held back results in order
+	if buffering {
+		buffering = false
+		sortResults(sortBuffer, sortKey, reverseSort)
+		for _, result := range trimResults(sortBuffer, sortKey, reverseSort, topResults) {
+			report(result)
+		}
+	}
+
+	if interactive {
+		if err := runInteractive(browseResults, stringPatternRegex); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if tree && outputFormat == "text" && !dupesMode {
+		printTree(root, treeResults, verbose)
+	}
+
+	if outputFormat == "sarif" {
+		printSarif(reportResults, contentRules)
+	}
+	if outputFormat == "html" {
+		printHTML(root, reportResults, stringPatternRegex, matchCount)
+	}
+
+	// Report files with identical content
+	if dupesMode {
+		fileCount, byteCount = reportDuplicates(dupeCandidates, dupesKeep, dupesAction, writeFiles, outputFormat, errors)
+	}
+
+	// Wait for the commands run for matched files to finish
+	execStatus := 0
+	if action != nil {
+		execStatus = action.wait()
+	}
+
+	if verbose && outputFormat == "text" && !dupesMode {
+		fmt.Println("\n"+colorize("hd", "- files:"), fileCount)
+		fmt.Printf("%s %d (%s)\n", colorize("hd", "- bytes:"), byteCount, colorize("hi", humanizeBytes(byteCount)))
 
 		if !(stringPatternRegex == nil && hexPatternRegex == nil && metaPatternRegex == nil) {
-			fmt.Println("\x1b[36m- matches:\x1b[0m", matchCount)
+			fmt.Println(colorize("hd", "- matches:"), matchCount)
 		}
 		fmt.Printf("\n")
 	}
+
+	if stats != nil && !dupesMode {
+		printStats(stats.report(), outputFormat)
+	}
+
+	// Search changed paths again and report files which started or stopped matching
+	for w != nil {
+		paths, err := w.next()
+		if err != nil {
+			fmt.Printf("Error watching for changes: %v\n", err)
+			os.Exit(1)
+		}
+		for _, path := range paths {
+			found := make(map[string]Result)
+			observe = func(result Result) {
+				found[result.Path] = result
+			}
+			if _, err := os.Lstat(path); err == nil {
+				Walk(path, links, search)
+			}
+			observe = nil
+
+			var gone, matching []string
+			for matched := range watchState {
+				if _, ok := found[matched]; !ok && (matched == path || strings.HasPrefix(matched, path+string(os.PathSeparator))) {
+					gone = append(gone, matched)
+				}
+			}
+			for matched := range found {
+				matching = append(matching, matched)
+			}
+			sort.Strings(gone)
+			sort.Strings(matching)
+
+			for _, matched := range gone {
+				delete(watchState, matched)
+				printWatchEvent(watchRemoved, Result{Path: matched}, verbose, outputFormat)
+			}
+			for _, matched := range matching {
+				result := found[matched]
+				before, ok := watchState[matched]
+				watchState[matched] = result.Matches
+				if !ok {
+					printWatchEvent(watchAdded, result, verbose, outputFormat)
+				} else if fresh := newMatches(before, result.Matches); len(fresh) > 0 {
+					result.Matches = fresh
+					printWatchEvent(watchChanged, result, verbose, outputFormat)
+				}
+			}
+		}
+	}
+
+	// Exit with the worst status of the commands run for matched files
+	if execStatus != 0 {
+		os.Exit(execStatus)
+	}
 }
 
-func printResults(fileCount int, lastDir string, directory string, filename string, metaData Metadata, fi os.FileInfo, byteCount int64, matchCount int, verbose bool, tree bool, errors bool) (string, int, int, int64) {
+func printResults(fileCount int, lastDir string, directory string, filename string, metaData Metadata, fi os.FileInfo, byteCount int64, matchCount int, verbose bool, errors bool) (string, int, int, int64) {
 
 	if verbose {
 		// Print directory
@@ -232,61 +721,48 @@ func printResults(fileCount int, lastDir string, directory string, filename stri
 				// directory is a symlink, print final path in light yellow with arrow pointing to actual path in regular green
 				finalPath, err := filepath.EvalSymlinks(lastDir)
 				if err != nil {
-					fmt.Printf("\n\033[38;5;221m%s\033[0m (could not resolve symlink):\n", lastDir)
+					fmt.Printf("\n%s (could not resolve symlink):\n", colorize("ln", lastDir))
 				} else {
-					fmt.Printf("\n\033[38;5;221m%s\033[0m --> \033[32m%s\033[0m:\n", lastDir, finalPath)
+					fmt.Printf("\n%s --> %s:\n", colorize("ln", lastDir), colorize("di", finalPath))
 				}
 			} else {
-				fmt.Printf("\n\033[32m%s\033[0m:\n", lastDir)
+				fmt.Printf("\n%s:\n", colorize("di", lastDir))
 			}
 		}
 
 		// Print the current file details
-		sizeStr := fmt.Sprintf("%*d", sizeWidth, metaData.Size)
-		modeStr := formatColumn(metaData.Mode, modeWidth)
-		if metaData.Suid {
-			modeStr = fmt.Sprintf("\x1b[31m%s\x1b[0m", modeStr)
-		}
-		ownerStr := formatColumn(metaData.Owner, ownerWidth)
-		groupStr := formatColumn(metaData.Group, groupWidth)
-		timeStr := formatColumn(metaData.ModTime, timeWidth)
-		mimeTypeStr := formatColumn(metaData.MimeType, mimeTypeWidth)
-		fileStr := filename
-		if metaData.Link != "" {
-			// file is a link, color it light yellow
-			fileStr = fmt.Sprintf("\x1b[38;5;221m%s\x1b[0m --> %s", filename, metaData.Link)
-		} else {
-			if fi.Mode().Perm()&0111 != 0 {
-				if fi.Mode().Perm()&0007 != 0 {
-					// file is world executable, color it dark red
-					fileStr = fmt.Sprintf("\x1b[38;5;124m%s\x1b[0m", filename)
-				} else if fi.Mode().Perm()&0070 != 0 {
-					// file is group executable, color it light red
-					fileStr = fmt.Sprintf("\x1b[38;5;211m%s\x1b[0m", filename)
-				} else {
-					// file is owner executable, color it light pink
-					fileStr = fmt.Sprintf("\x1b[38;5;219m%s\x1b[0m", filename)
-				}
-			} else {
-				fileStr = fmt.Sprintf("\x1b[38;5;117m%s\x1b[0m", filename)
-			}
+		if metaData.Link == "" {
 			// Exclude symlinks from byteCount
 			byteCount += metaData.Size
 		}
 
 		var errorStr string
 		if errors {
-			errorStr = fmt.Sprintf("\033[90m - %s\033[0m", metaData.Error)
+			errorStr = colorize("dm", " - "+metaData.Error)
 		}
 
-		fmt.Printf("%s %s %s %s %s %s %s %s\n", modeStr, ownerStr, groupStr, sizeStr, timeStr, mimeTypeStr, fileStr, errorStr)
-	} else if tree {
-        depth := strings.Count(directory, string(os.PathSeparator))
-        indent := strings.Repeat(" ", depth)
-        fmt.Println(indent + " " + filename)
+		fmt.Printf("%s %s\n", renderRow(listColumns, metaData, fi, directory, filename), errorStr)
+
+		// Print what is inside executables below the file details
+		if metaData.Exe != nil {
+			fmt.Printf("%*s%s\n", modeWidth+1, "", colorize("dm", metaData.Exe.String()))
+		}
+		if metaData.Capabilities != "" {
+			fmt.Printf("%*s%s\n", modeWidth+1, "", colorize("ca", "caps: "+metaData.Capabilities))
+		}
+		if metaData.ACL != "" {
+			fmt.Printf("%*s%s\n", modeWidth+1, "", colorize("dm", "acl: "+metaData.ACL))
+		}
+		for _, hash := range formatHashes(hashesOutsideColumns(metaData.Hashes, listColumns)) {
+			fmt.Printf("%*s%s\n", modeWidth+1, "", colorize("dm", hash))
+		}
 	} else {
-		// Default printing (neither verbose nor tree)
-		fmt.Printf("%s/%s\n", directory, filename)
+		// Default printing
+		if print0 {
+			fmt.Printf("%s/%s\x00", directory, filename)
+		} else {
+			fmt.Printf("%s/%s\n", directory, filename)
+		}
 	}
 
 	fileCount++
@@ -296,6 +772,7 @@ func printResults(fileCount int, lastDir string, directory string, filename stri
 
 func parseFlags() (bool, bool, bool, bool, string, int, *regexp.Regexp, *regexp.Regexp, *regexp.Regexp, *regexp.Regexp, bool, ignore.IgnoreParser, bool) {
 	var filePattern, stringPattern, hexPattern, metaPattern string
+	var hashSpec, hashListFile, columnSpec, templateText, templateFile, colorMode, rulesFile string
 	var verbose, binary, errors, globalPattern, links, tree bool
 	var root string
 	var depth int
@@ -314,22 +791,210 @@ func parseFlags() (bool, bool, bool, bool, string, int, *regexp.Regexp, *regexp.
 	pflag.BoolVarP(&globalPattern, "global", "g", false, "search all including .gitignore paths")
 	pflag.BoolVarP(&tree, "tree", "t", false, "display results in a tree format")
 	pflag.IntVarP(&depth, "depth", "d", -1, "depth to recurse, -1 for infinite depth")
-	pflag.Parse()
+	pflag.BoolVar(&binaryMatches, "binary-matches", false, "search binary files but only report that they match")
+	pflag.StringVar(&textEncoding, "encoding", "auto", "text encoding of searched files (auto, utf-8, utf-16le, utf-16be, latin1)")
+	pflag.StringVar(&replaceTemplate, "replace", "", "replace matches of the string pattern, $1 and ${name} expand to capture groups")
+	pflag.BoolVar(&writeFiles, "write", false, "apply --replace and --dupes-action changes instead of previewing them")
+	pflag.BoolVar(&dupesMode, "dupes", false, "report sets of files with identical content")
+	pflag.StringVar(&dupesKeep, "dupes-keep", "first", "file of each duplicate set to keep (first, oldest, shortest)")
+	pflag.StringVar(&dupesAction, "dupes-action", "", "what to do with the other files of each set (hardlink, delete)")
+	pflag.BoolVarP(&print0, "print0", "0", false, "end each printed path with a NUL instead of a newline")
+	pflag.BoolVar(&globOperands, "glob-operands", false, "treat operands as file name patterns searched for below the current directory")
+	pflag.StringVar(&filesFrom, "files-from", "", "search the paths listed in a file, - for stdin, instead of walking root")
+	pflag.IntVar(&execJobs, "exec-jobs", 1, "number of --exec commands to run at the same time")
+	pflag.StringVar(&outputFormat, "format", "text", "output format (text, json, sarif, html)")
+	pflag.BoolVar(&vimgrepMode, "vimgrep", false, "print every match as path:line:column:text for editors")
+	pflag.StringVar(&rulesFile, "rules", "", "file of id=regex content patterns, searched for together and reported by id")
+	pflag.StringVar(&templateText, "template", "", "print each result with a Go text/template, \\t and \\n are a tab and a newline")
+	pflag.StringVar(&templateFile, "template-file", "", "print each result with the Go text/template in a file")
+	pflag.StringVar(&magicFile, "magic", "", "file of extra magic signatures used to identify file types")
+	pflag.StringVar(&colorMode, "color", "auto", "color output: auto (when printing to a terminal and NO_COLOR is unset), always or never")
+	pflag.StringVar(&columnSpec, "columns", defaultColumns, "comma separated columns of the verbose listing")
+	pflag.StringVar(&timeFormat, "time-format", "", "modification time layout of the verbose listing (iso, date, ls, unix, relative or a Go layout)")
+	pflag.BoolVar(&humanSizes, "human", false, "show sizes in the verbose listing as KB, MB, ...")
+	pflag.StringVar(&hashSpec, "hash", "", "compute digests of matched files (md5, sha1, sha256, sha512), comma separated")
+	pflag.BoolVar(&useCache, "cache", false, "remember file metadata between runs, only re-reading changed files")
+	pflag.StringVar(&sortKey, "sort", "", "sort results by name, path, size, mtime or matches")
+	pflag.BoolVar(&reverseSort, "reverse", false, "reverse the sort order")
+	pflag.IntVar(&topResults, "top", 0, "only report the first N results, after sorting")
+	pflag.BoolVar(&statsMode, "stats", false, "report totals by extension, type, owner, directory and size")
+	pflag.BoolVar(&interactive, "interactive", false, "browse the results in the terminal, with a preview of each file")
+	pflag.BoolVar(&watchMode, "watch", false, "keep running, reporting files which start or stop matching as they change")
+	pflag.BoolVar(&noIndex, "no-index", false, "scan every file even when an index of root exists")
+	pflag.StringVar(&hashListFile, "hash-list", "", "only match files whose digest is listed in a file, one per line")
+
+	// Take out --exec before pflag sees it, its arguments run up to ; or +
+	args, execCommand, batch, err := extractExecArgs(os.Args[1:], pflag.CommandLine)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	execArgs, execBatch = execCommand, batch
+	pflag.CommandLine.Parse(args)
+
+	if dupesKeep != "first" && dupesKeep != "oldest" && dupesKeep != "shortest" {
+		fmt.Printf("Error: unknown --dupes-keep '%s'.\n", dupesKeep)
+		os.Exit(1)
+	}
+	if dupesAction != "" && dupesAction != "hardlink" && dupesAction != "delete" {
+		fmt.Printf("Error: unknown --dupes-action '%s'.\n", dupesAction)
+		os.Exit(1)
+	}
+
+	if sortKey != "" && !sortKeys[sortKey] {
+		fmt.Printf("Error: unknown sort key '%s'.\n", sortKey)
+		os.Exit(1)
+	}
+	if reverseSort && sortKey == "" {
+		fmt.Printf("Error: --reverse requires --sort.\n")
+		os.Exit(1)
+	}
+	if topResults < 0 {
+		fmt.Printf("Error: --top must not be negative.\n")
+		os.Exit(1)
+	}
+
+	if err := setupColor(colorMode); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if outputFormat != "text" && outputFormat != "json" && outputFormat != "sarif" && outputFormat != "html" {
+		fmt.Printf("Error: unknown output format '%s'.\n", outputFormat)
+		os.Exit(1)
+	}
+
+	outputTemplate = nil
+	if templateText != "" || templateFile != "" {
+		if templateText != "" && templateFile != "" {
+			fmt.Printf("Error: --template and --template-file cannot be used together.\n")
+			os.Exit(1)
+		}
+		if pflag.CommandLine.Changed("format") || dupesMode {
+			fmt.Printf("Error: --template cannot be used with --format or --dupes.\n")
+			os.Exit(1)
+		}
+		outputTemplate, err = parseOutputTemplate(templateText, templateFile)
+		if err != nil {
+			fmt.Printf("Error parsing template: %v\n", err)
+			os.Exit(1)
+		}
+		outputFormat = "template"
+	}
+
+	hashAlgorithms, err = parseHashAlgorithms(hashSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	listColumns, err = parseColumns(columnSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fitColumnsToTerminal(listColumns)
+	// Digest columns need their digests computed
+	hashAlgorithms, _ = parseHashAlgorithms(strings.Join(append(hashAlgorithms, columnHashes(listColumns)...), ","))
+	hashList = nil
+	if hashListFile != "" {
+		// Also compute whichever digests the list holds
+		var needed []string
+		hashList, needed, err = loadHashList(hashListFile)
+		if err != nil {
+			fmt.Printf("Error loading hash list: %v\n", err)
+			os.Exit(1)
+		}
+		hashAlgorithms, _ = parseHashAlgorithms(strings.Join(append(hashAlgorithms, needed...), ","))
+	}
+
+	magicTable = builtinMagicTable
+	if magicFile != "" {
+		if err := loadMagicFile(magicFile); err != nil {
+			fmt.Printf("Error loading magic file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	textEncoding, err = normalizeEncoding(textEncoding)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Rules are searched for as one pattern, the -s pattern being one more rule
+	contentRules = nil
+	if rulesFile != "" {
+		if hexPattern != "" {
+			fmt.Printf("Error: --rules cannot be used with --hex.\n")
+			os.Exit(1)
+		}
+		rules, err := loadRules(rulesFile)
+		if err != nil {
+			fmt.Printf("Error loading rules: %v\n", err)
+			os.Exit(1)
+		}
+		if len(rules) == 0 {
+			fmt.Printf("Error: no rules in %s.\n", rulesFile)
+			os.Exit(1)
+		}
+		if stringPattern != "" {
+			pattern, err := regexp.Compile(stringPattern)
+			if err != nil {
+				fmt.Printf("Error compiling string pattern regex: %v\n", err)
+				os.Exit(1)
+			}
+			rules = append([]contentRule{{ID: patternRuleID, Regex: pattern}}, rules...)
+		}
+		contentRules = rules
+		stringPattern = combineRules(rules)
+	}
 
 	rootArgs := pflag.Args()
-	if len(rootArgs) > 0 {
+	operandPaths = nil
+	searchStdin = false
+	if !globOperands {
+		root = "."
+		homedir, _ := os.UserHomeDir()
+		for _, arg := range rootArgs {
+			if arg == "-" {
+				searchStdin = true
+				continue
+			}
+			path := strings.Replace(arg, "~", homedir, 1)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				fmt.Printf("Error: '%s' does not exist.\n", path)
+				os.Exit(1)
+			}
+			operandPaths = append(operandPaths, path)
+		}
+
+		// A lone directory operand is the root, searched with its .gitignore
+		if len(operandPaths) == 1 && !searchStdin {
+			if info, err := os.Stat(operandPaths[0]); err == nil && info.IsDir() {
+				root = operandPaths[0]
+				operandPaths = nil
+			}
+		}
+
+		// Search piped input when given a content pattern and nothing else to search
+		if len(rootArgs) == 0 && filesFrom == "" && (stringPattern != "" || hexPattern != "") && stdinIsPiped() {
+			searchStdin = true
+		}
+		if searchStdin && filesFrom == "-" {
+			fmt.Printf("Error: standard input cannot be searched and read as a file list.\n")
+			os.Exit(1)
+		}
+	} else if len(rootArgs) > 0 {
 		homedir, _ := os.UserHomeDir()
 		root = strings.Replace(rootArgs[0], "~", homedir, 1)
 		if len(rootArgs) > 1 {
 			root = "."
 			filePattern = strings.Join(rootArgs, "|")
 		} else {
+			// A pattern the shell did not expand is searched for as is
 			info, err := os.Stat(root)
-			if os.IsNotExist(err) {
-				fmt.Printf("Error: directory '%s' does not exist.\n", root)
-				os.Exit(1)
-			}
-			if !info.IsDir() {
+			if os.IsNotExist(err) || !info.IsDir() {
 				filePattern = root
 				root = "."
 			}
@@ -357,6 +1022,9 @@ func parseFlags() (bool, bool, bool, bool, string, int, *regexp.Regexp, *regexp.
 			fmt.Printf("Error compiling string pattern regex: %v\n", err)
 			os.Exit(1)
 		}
+		if contentRules == nil {
+			contentRules = []contentRule{{ID: patternRuleID, Regex: stringPatternRegex}}
+		}
 	}
 
 	if hexPattern != "" {
@@ -365,6 +1033,46 @@ func parseFlags() (bool, bool, bool, bool, string, int, *regexp.Regexp, *regexp.
 			fmt.Printf("Error compiling hex pattern regex: %v\n", err)
 			os.Exit(1)
 		}
+		// Hex patterns take over lines from string patterns
+		contentRules = []contentRule{{ID: patternRuleID, Regex: hexPatternRegex}}
+	}
+
+	replaceMode = pflag.CommandLine.Changed("replace")
+	if replaceMode && (stringPatternRegex == nil || hexPattern != "") {
+		fmt.Printf("Error: --replace requires a string pattern and cannot be used with --hex.\n")
+		os.Exit(1)
+	}
+
+	if vimgrepMode {
+		if stringPatternRegex == nil || hexPatternRegex != nil {
+			fmt.Printf("Error: --vimgrep requires a string pattern and cannot be used with --hex.\n")
+			os.Exit(1)
+		}
+		if pflag.CommandLine.Changed("format") || outputTemplate != nil || dupesMode || replaceMode || watchMode || statsMode {
+			fmt.Printf("Error: --vimgrep cannot be used with --format, --template, --dupes, --replace, --watch or --stats.\n")
+			os.Exit(1)
+		}
+		outputFormat = "vimgrep"
+	}
+	// Looking inside executables is only worth it when something shows what is
+	// found, or for the cache which must hold everything a later run may show
+	inspectExecutables = verbose || metaPattern != "" || outputFormat == "json" || outputFormat == "template" || useCache
+
+	if interactive && (dupesMode || replaceMode || len(execArgs) > 0 || watchMode || outputFormat != "text") {
+		fmt.Printf("Error: --interactive cannot be used with --dupes, --replace, --exec, --watch or --format.\n")
+		os.Exit(1)
+	}
+	if (outputFormat == "sarif" || outputFormat == "html") && (dupesMode || replaceMode || watchMode || statsMode) {
+		fmt.Printf("Error: --format %s cannot be used with --dupes, --replace, --watch or --stats.\n", outputFormat)
+		os.Exit(1)
+	}
+	if dupesMode && len(execArgs) > 0 {
+		fmt.Printf("Error: --dupes cannot be used with --exec, use --dupes-action to act on duplicates.\n")
+		os.Exit(1)
+	}
+	if watchMode && (dupesMode || replaceMode || len(execArgs) > 0 || filesFrom == "-" || searchStdin) {
+		fmt.Printf("Error: --watch cannot be used with --dupes, --replace, --exec or standard input.\n")
+		os.Exit(1)
 	}
 
 	if metaPattern != "" {
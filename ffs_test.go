@@ -1,13 +1,26 @@
 package main
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"io"
 	"strings"
 	"bytes"
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
 	"os"
 	"path/filepath"
+	"regexp"
+	"os/user"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -715,3 +728,1508 @@ func TestSearchWithBinaryFlag(t *testing.T) {
         t.Errorf("Expected matchCount: %d, Got: %d", expectedMatchCount, matchCount)
     }
 }
+
+func TestSearchUTF16(t *testing.T) {
+    setup()
+
+    testDir := "./tests/fixtures"
+    if err := os.Mkdir(testDir, 0755); err != nil {
+        t.Fatalf("Could not create temp directory: %v", err)
+    }
+    defer os.RemoveAll(testDir)
+
+    // Encode "Windows Registry Editor\r\nsample" as UTF-16LE with a byte order mark
+    content := []byte{0xff, 0xfe}
+    for _, r := range "Windows Registry Editor\r\nsample" {
+        content = append(content, byte(r), 0x00)
+    }
+    utf16FilePath := filepath.Join(testDir, "export.reg")
+    if err := ioutil.WriteFile(utf16FilePath, content, 0644); err != nil {
+        t.Fatalf("Could not create export.reg: %v", err)
+    }
+
+    os.Args = []string{"ffs", testDir, "--string", "^sample$", "--verbose", "--global"}
+    main()
+
+    expectedFileCount := 1     		// The UTF-16 file should be decoded and matched
+    expectedMatchCount := 1    		// One match on the second line
+
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    if matchCount != expectedMatchCount {
+        t.Errorf("Expected matchCount: %d, Got: %d", expectedMatchCount, matchCount)
+    }
+}
+
+func TestDetectEncoding(t *testing.T) {
+    tests := []struct {
+        name     string
+        content  []byte
+        expected string
+    }{
+        {"utf-16le", []byte("s\x00a\x00m\x00p\x00"), encodingUTF16LE},
+        {"utf-16be", []byte("\x00s\x00a\x00m\x00p"), encodingUTF16BE},
+        {"utf-8", []byte("sampé"), encodingUTF8},
+        {"latin1", []byte("samp\xe9"), encodingLatin1},
+        // Too short for a lone NUL to be taken for UTF-16
+        {"short binary", []byte("ELF\x00\x01"), ""},
+        {"short utf-16le", []byte("s\x00a\x00m\x00"), ""},
+    }
+
+    for _, test := range tests {
+        if got := detectEncoding(test.content); got != test.expected {
+            t.Errorf("%s: expected encoding %q, Got: %q", test.name, test.expected, got)
+        }
+    }
+}
+
+func TestReplaceNonPrintable(t *testing.T) {
+    tests := map[string]string{
+        "plain text":        "plain text",
+        "tab\there":         "tab.here",
+        "café 日本": "café 日本",
+        "bad \xff byte":     "bad . byte",
+    }
+
+    for input, expected := range tests {
+        if got := replaceNonPrintable(input); got != expected {
+            t.Errorf("replaceNonPrintable(%q): expected %q, Got: %q", input, expected, got)
+        }
+    }
+}
+
+func TestClassifyContent(t *testing.T) {
+    tests := []struct {
+        name     string
+        content  []byte
+        mimeType string
+        expected string
+    }{
+        {"empty", []byte{}, "text/plain; charset=utf-8", kindEmpty},
+        {"json", []byte(`{"key": "value"}`), "application/json", kindText},
+        {"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml", kindText},
+        {"javascript", []byte("console.log('hi');"), "application/octet-stream", kindText},
+        {"nul", []byte{'E', 'L', 'F', 0x00, 0x01}, "text/plain", kindBinary},
+        {"png", []byte("\x89PNG\r\n\x1a\n"), "image/png", kindBinary},
+        {"control", []byte{0x01, 0x02, 0x03, 0x04, 'a'}, "application/octet-stream", kindBinary},
+    }
+
+    for _, test := range tests {
+        if got := classifyContent(test.content, test.mimeType, detectEncoding(test.content)); got != test.expected {
+            t.Errorf("%s: expected kind %q, Got: %q", test.name, test.expected, got)
+        }
+    }
+}
+
+func TestSearchWithBinaryMatchesFlag(t *testing.T) {
+    setup()
+
+    testDir := "./tests/fixtures"
+    if err := os.MkdirAll(testDir, 0755); err != nil {
+        t.Fatalf("Could not create temp directory: %v", err)
+    }
+    defer os.RemoveAll(testDir)
+
+    binaryFilePath := filepath.Join(testDir, "binaryFile.bin")
+    if err := ioutil.WriteFile(binaryFilePath, []byte{0x00, 0x01, 0x02, 0x03, 'S', 'e', 'a', 'r', 'c', 'h'}, 0644); err != nil {
+        t.Fatalf("Could not create binaryFile: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--string", "Search", "--binary-matches", "--verbose", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    capturedOutput := buf.String()
+
+    if fileCount != 1 {
+        t.Errorf("Expected fileCount: %d, Got: %d", 1, fileCount)
+    }
+
+    if !strings.Contains(capturedOutput, "matches") || strings.Contains(capturedOutput, ":1:") {
+        t.Errorf("Expected a binary file summary instead of matched lines, Got: %q", capturedOutput)
+    }
+}
+
+func TestIdentifyMagic(t *testing.T) {
+    magicTable = builtinMagicTable
+
+    tests := []struct {
+        name     string
+        content  []byte
+        expected string
+    }{
+        {"elf", []byte("\x7fELF\x02\x01\x01\x00"), "ELF executable"},
+        {"sqlite", []byte("SQLite format 3\x00\x10\x00"), "SQLite 3 database"},
+        {"pdf", []byte("\n\n  %PDF-1.7\n"), "PDF document"},
+        {"java", []byte("\xca\xfe\xba\xbe\x00\x00\x00\x34"), "compiled Java class data"},
+        {"fat", []byte("\xca\xfe\xba\xbe\x00\x00\x00\x02"), "Mach-O universal binary"},
+        {"macho", []byte("\xcf\xfa\xed\xfe\x07\x00\x00\x01"), "Mach-O executable"},
+        {"pe", append(append([]byte("MZ"), make([]byte, 0x3a)...), "\x40\x00\x00\x00PE\x00\x00"...), "PE executable"},
+    }
+
+    for _, test := range tests {
+        entry := identifyMagic(test.content)
+        if entry == nil {
+            t.Errorf("%s: expected %q, Got: no match", test.name, test.expected)
+        } else if entry.Description != test.expected {
+            t.Errorf("%s: expected %q, Got: %q", test.name, test.expected, entry.Description)
+        }
+    }
+
+    if entry := identifyMagic([]byte("plain old text")); entry != nil {
+        t.Errorf("Expected no match for text, Got: %q", entry.Description)
+    }
+
+    // Text which merely starts with MZ is not an executable
+    text := []byte("MZ is how the notes of Mozart's letters are signed, there are more of them below.\n")
+    if entry := identifyMagic(text); entry != nil {
+        t.Errorf("Expected no match for text starting with MZ, Got: %q", entry.Description)
+    }
+    textPath := filepath.Join(t.TempDir(), "notes.txt")
+    if err := ioutil.WriteFile(textPath, text, 0644); err != nil {
+        t.Fatalf("Could not create text file: %v", err)
+    }
+    file, err := os.Open(textPath)
+    if err != nil {
+        t.Fatalf("Could not open text file: %v", err)
+    }
+    defer file.Close()
+    stat, _ := file.Stat()
+    if meta, _, _ := extractFileData(file, stat); meta.Kind != kindText {
+        t.Errorf("Expected text starting with MZ to be %q, Got: %q", kindText, meta.Kind)
+    }
+}
+
+func TestSearchWithMagicFile(t *testing.T) {
+    setup()
+
+    testDir := "./tests/fixtures"
+    if err := os.Mkdir(testDir, 0755); err != nil {
+        t.Fatalf("Could not create temp directory: %v", err)
+    }
+    defer os.RemoveAll(testDir)
+
+    magicPath := filepath.Join(testDir, "magic")
+    if err := ioutil.WriteFile(magicPath, []byte("# custom formats\n~0 464653 - application/x-ffs ffs test format\n"), 0644); err != nil {
+        t.Fatalf("Could not create magic file: %v", err)
+    }
+
+    dataPath := filepath.Join(testDir, "data.bin")
+    if err := ioutil.WriteFile(dataPath, []byte("  FFS\x00\x01\x02"), 0644); err != nil {
+        t.Fatalf("Could not create data file: %v", err)
+    }
+
+    os.Args = []string{"ffs", testDir, "--magic", magicPath, "--meta", "ffs test format", "--binary", "--verbose", "--global"}
+    main()
+    magicTable = builtinMagicTable
+
+    expectedFileCount := 1     		// Only data.bin is identified by the custom signature
+    expectedMatchCount := 1
+
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    if matchCount != expectedMatchCount {
+        t.Errorf("Expected matchCount: %d, Got: %d", expectedMatchCount, matchCount)
+    }
+}
+
+func TestInspectExecutable(t *testing.T) {
+    if runtime.GOOS != "linux" {
+        t.Skip("test binary is only an ELF file on linux")
+    }
+
+    executable, err := os.Executable()
+    if err != nil {
+        t.Fatalf("Could not locate test binary: %v", err)
+    }
+
+    file, err := os.Open(executable)
+    if err != nil {
+        t.Fatalf("Could not open test binary: %v", err)
+    }
+    defer file.Close()
+
+    buf := make([]byte, 64)
+    if _, err := io.ReadFull(file, buf); err != nil {
+        t.Fatalf("Could not read test binary: %v", err)
+    }
+
+    stat, err := file.Stat()
+    if err != nil {
+        t.Fatalf("Could not stat test binary: %v", err)
+    }
+
+    info := inspectExecutable(file, buf, stat.Size())
+    if info == nil {
+        t.Fatalf("Expected executable details for %s, Got: nil", executable)
+    }
+    if info.Format != "elf" || info.Arch == "" || info.Linking == "" {
+        t.Errorf("Expected elf format with arch and linking, Got: %s", info)
+    }
+    if inspectExecutable(file, []byte("plain text"), 10) != nil {
+        t.Errorf("Expected no executable details for text")
+    }
+
+    // An ELF header claiming an interpreter far larger than the file
+    crafted := make([]byte, 64+56)
+    copy(crafted, "\x7fELF\x02\x01\x01")
+    binary.LittleEndian.PutUint16(crafted[16:], uint16(elf.ET_EXEC))
+    binary.LittleEndian.PutUint16(crafted[18:], uint16(elf.EM_X86_64))
+    binary.LittleEndian.PutUint32(crafted[20:], 1)
+    binary.LittleEndian.PutUint64(crafted[32:], 64)
+    binary.LittleEndian.PutUint16(crafted[52:], 64)
+    binary.LittleEndian.PutUint16(crafted[54:], 56)
+    binary.LittleEndian.PutUint16(crafted[56:], 1)
+    binary.LittleEndian.PutUint32(crafted[64:], uint32(elf.PT_INTERP))
+    binary.LittleEndian.PutUint64(crafted[64+8:], 64)
+    binary.LittleEndian.PutUint64(crafted[64+32:], 1<<62)
+    binary.LittleEndian.PutUint64(crafted[64+40:], 1<<62)
+    if inspectExecutable(bytes.NewReader(crafted), crafted, int64(len(crafted))) != nil {
+        t.Errorf("Expected no executable details for an ELF with an oversized interpreter")
+    }
+}
+
+func TestDecodeXattrs(t *testing.T) {
+    // VFS_CAP_REVISION_2 with the effective flag, cap_setuid (bit 7) permitted
+    capability := []byte{
+        0x01, 0x00, 0x00, 0x02,
+        0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+        0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+    }
+    if got := decodeCapability(capability); got != "cap_setuid+ep" {
+        t.Errorf("Expected capability %q, Got: %q", "cap_setuid+ep", got)
+    }
+
+    // user::rw-, user:1000:r--, group::r--, mask::r--, other::---
+    acl := []byte{
+        0x02, 0x00, 0x00, 0x00,
+        0x01, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff,
+        0x02, 0x00, 0x04, 0x00, 0xe8, 0x03, 0x00, 0x00,
+        0x04, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff,
+        0x10, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff,
+        0x20, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff,
+    }
+    expectedACL := "user::rw-,user:1000:r--,group::r--,mask::r--,other::---"
+    if got := decodeACL(acl, ""); got != expectedACL {
+        t.Errorf("Expected ACL %q, Got: %q", expectedACL, got)
+    }
+}
+
+func TestReplaceFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    file1Path := filepath.Join(testDir, "file1.txt")
+    if err := os.Chmod(file1Path, 0640); err != nil {
+        t.Fatalf("Could not chmod file1: %v", err)
+    }
+    before, err := os.Stat(file1Path)
+    if err != nil {
+        t.Fatalf("Could not stat file1: %v", err)
+    }
+
+    // Without --write only a diff is printed
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--file", "file1", "--string", "(a) (sample)", "--replace", "${2}_$1", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    capturedOutput := buf.String()
+
+    if !strings.Contains(capturedOutput, "-This is a sample text.") || !strings.Contains(capturedOutput, "+This is sample_a text.") {
+        t.Errorf("Expected a unified diff of the replacement, Got: %q", capturedOutput)
+    }
+    if content, _ := ioutil.ReadFile(file1Path); string(content) != "This is a sample text." {
+        t.Errorf("Expected dry run to leave file1 untouched, Got: %q", content)
+    }
+
+    // With --write the file is rewritten in place
+    setup()
+    os.Args = []string{"ffs", testDir, "--file", "file1", "--string", "(a) (sample)", "--replace", "${2}_$1", "--write", "--global"}
+    main()
+
+    if content, _ := ioutil.ReadFile(file1Path); string(content) != "This is sample_a text." {
+        t.Errorf("Expected file1 to be rewritten, Got: %q", content)
+    }
+
+    after, err := os.Stat(file1Path)
+    if err != nil {
+        t.Fatalf("Could not stat file1: %v", err)
+    }
+    if after.Mode() != before.Mode() || !after.ModTime().Equal(before.ModTime()) {
+        t.Errorf("Expected mode %v and mtime %v to be preserved, Got: %v and %v", before.Mode(), before.ModTime(), after.Mode(), after.ModTime())
+    }
+}
+
+func TestExecFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    outDir := "./tests/exec_output"
+    if err := os.Mkdir(outDir, 0755); err != nil {
+        t.Fatalf("Could not create output directory: %v", err)
+    }
+    defer os.RemoveAll(outDir)
+
+    // Copy each matching file, naming the copy after its first matching line
+    os.Args = []string{"ffs", testDir, "--string", "another", "--exec", "cp", "{}", outDir + "/{/}.{line}", ";", "--global"}
+    main()
+
+    if _, err := os.Stat(filepath.Join(outDir, "file2.txt.1")); err != nil {
+        t.Errorf("Expected --exec to copy file2.txt: %v", err)
+    }
+
+    // Commands still run when results are printed as a tree
+    setup()
+    os.Args = []string{"ffs", testDir, "--string", "sample", "--tree", "--exec", "cp", "{}", outDir + "/{/}.tree", ";", "--global"}
+    main()
+
+    if _, err := os.Stat(filepath.Join(outDir, "file1.txt.tree")); err != nil {
+        t.Errorf("Expected --exec with --tree to copy file1.txt: %v", err)
+    }
+
+    // A batched command receives every matching file at once
+    setup()
+    os.Args = []string{"ffs", testDir, "--string", "sample", "--exec", "cp", "-t", outDir, "{}", "+", "--global"}
+    main()
+
+    for _, name := range []string{"file1.txt", "file2.txt"} {
+        if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+            t.Errorf("Expected batched --exec to copy %s: %v", name, err)
+        }
+    }
+}
+
+func TestExtractExecArgs(t *testing.T) {
+    flags := pflag.NewFlagSet("ffs", pflag.ContinueOnError)
+    flags.StringP("string", "s", "", "")
+    flags.BoolP("ignore-case", "i", false, "")
+
+    rest, command, batch, err := extractExecArgs([]string{"-s", "foo", "--exec", "rm", "{}", "+", "dir"}, flags)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if strings.Join(rest, " ") != "-s foo dir" || strings.Join(command, " ") != "rm {}" || !batch {
+        t.Errorf("Expected rest %q, command %q and batch, Got: %q, %q, %v", "-s foo dir", "rm {}", rest, command, batch)
+    }
+
+    if _, _, _, err := extractExecArgs([]string{"--exec", "rm", "{}"}, flags); err == nil {
+        t.Errorf("Expected an error for an unterminated --exec")
+    }
+    if _, _, _, err := extractExecArgs([]string{"--exec", "vim", "+{line}", "{}", "+"}, flags); err == nil {
+        t.Errorf("Expected an error for {line} in a batched --exec")
+    }
+
+    // The paths of a batch only go where a lone {} ends the command
+    for _, command := range [][]string{{"cp", "{}", "dir"}, {"echo", "x{}", "{}"}, {"echo", "{}.bak"}, {"rm"}} {
+        args := append(append([]string{"--exec"}, command...), "+")
+        if _, _, _, err := extractExecArgs(args, flags); err == nil {
+            t.Errorf("Expected an error for the batched --exec %q", command)
+        }
+    }
+
+    // An --exec which is the value of a flag is searched for, not run
+    for _, args := range [][]string{{"-s", "--exec"}, {"--string", "--exec"}, {"-is", "--exec"}} {
+        rest, command, _, err := extractExecArgs(args, flags)
+        if err != nil || len(command) != 0 || strings.Join(rest, " ") != strings.Join(args, " ") {
+            t.Errorf("Expected %q to be left for pflag, Got: %q, %q, %v", args, rest, command, err)
+        }
+    }
+    rest, command, _, err = extractExecArgs([]string{"--string=x", "--exec", "rm", "{}", ";"}, flags)
+    if err != nil || strings.Join(rest, " ") != "--string=x" || strings.Join(command, " ") != "rm {}" {
+        t.Errorf("Expected --string=x to take no further argument, Got: %q, %q, %v", rest, command, err)
+    }
+}
+
+func TestExecBatchLimit(t *testing.T) {
+    outDir, err := ioutil.TempDir("", "ffs-exec")
+    if err != nil {
+        t.Fatalf("Could not create temp directory: %v", err)
+    }
+    defer os.RemoveAll(outDir)
+    out := filepath.Join(outDir, "batches")
+
+    // The paths alone fit one batch, but not with the fixed arguments
+    fixed := strings.Repeat("x", execArgMax/2)
+    action := newExecAction([]string{"sh", "-c", "echo $# >> " + out, fixed, "{}"}, true, 1)
+    for i := 0; i < 3; i++ {
+        action.add(strings.Repeat("p", execArgMax/5), 0)
+    }
+    if status := action.wait(); status != 0 {
+        t.Fatalf("Expected the batches to run, Got status: %d", status)
+    }
+
+    batches, _ := ioutil.ReadFile(out)
+    if lines := strings.Count(string(batches), "\n"); lines != 2 {
+        t.Errorf("Expected the paths to be split over 2 batches, Got: %q", batches)
+    }
+}
+
+func TestFilesFromAndPrint0(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    // A NUL separated list naming a file with a newline in it, and only one of the fixtures
+    oddPath := filepath.Join(testDir, "odd\nname.txt")
+    if err := ioutil.WriteFile(oddPath, []byte("This is a sample."), 0644); err != nil {
+        t.Fatalf("Could not create odd file: %v", err)
+    }
+    listPath := filepath.Join(testDir, "list")
+    list := oddPath + "\x00" + filepath.Join(testDir, "file1.txt") + "\x00"
+    if err := ioutil.WriteFile(listPath, []byte(list), 0644); err != nil {
+        t.Fatalf("Could not create file list: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", "--files-from", listPath, "--string", "sample", "--print0", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    capturedOutput := buf.String()
+
+    expectedFileCount := 2     		// Only the listed files are searched
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    if capturedOutput != list {
+        t.Errorf("Expected NUL separated output %q, Got: %q", list, capturedOutput)
+    }
+}
+
+func TestSearchStdinAndFileOperands(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    // Feed standard input from a pipe
+    oldStdin := os.Stdin
+    r, w, _ := os.Pipe()
+    os.Stdin = r
+    w.Write([]byte("first line\nanother sample line\n"))
+    w.Close()
+
+    os.Args = []string{"ffs", "--string", "sample", "-", filepath.Join(testDir, "file1.txt")}
+    main()
+
+    os.Stdin = oldStdin
+
+    expectedFileCount := 2     		// Standard input and file1.txt, but not file2.txt
+    expectedMatchCount := 2
+
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    if matchCount != expectedMatchCount {
+        t.Errorf("Expected matchCount: %d, Got: %d", expectedMatchCount, matchCount)
+    }
+
+    // Standard input is named with a NUL after it like any other path
+    setup()
+    r, w, _ = os.Pipe()
+    os.Stdin = r
+    w.Write([]byte("another sample line\n"))
+    w.Close()
+
+    oldStdout := os.Stdout
+    outR, outW, _ := os.Pipe()
+    os.Stdout = outW
+
+    os.Args = []string{"ffs", "--string", "sample", "--print0", "-"}
+    main()
+
+    outW.Close()
+    os.Stdout = oldStdout
+    os.Stdin = oldStdin
+
+    var buf bytes.Buffer
+    io.Copy(&buf, outR)
+    if expected := stdinName + "\x00"; buf.String() != expected {
+        t.Errorf("Expected NUL terminated output %q, Got: %q", expected, buf.String())
+    }
+}
+
+func TestGlobOperandsFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    // The operand is a name pattern searched for below the current directory
+    os.Args = []string{"ffs", "--glob-operands", "file2.txt", "--global"}
+    main()
+
+    expectedFileCount := 1
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+}
+
+func TestDupesFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    // A copy of file1.txt, and a file of the same size with different content
+    copyPath := filepath.Join(testDir, "copy_of_file1.txt")
+    if err := ioutil.WriteFile(copyPath, []byte("This is a sample text."), 0644); err != nil {
+        t.Fatalf("Could not create copy: %v", err)
+    }
+    if err := ioutil.WriteFile(filepath.Join(testDir, "same_size.txt"), []byte("This is a sample test."), 0644); err != nil {
+        t.Fatalf("Could not create same_size: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--dupes", "--dupes-keep", "shortest", "--dupes-action", "delete", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+
+    // The kept file and the dry run action line up
+    keepLine := "  keep         " + filepath.Join(testDir, "file1.txt") + "\n"
+    dupeLine := "  would delete " + copyPath + "\n"
+    if !strings.Contains(buf.String(), keepLine) || !strings.Contains(buf.String(), dupeLine) {
+        t.Errorf("Expected aligned rows %q and %q, Got:\n%s", keepLine, dupeLine, buf.String())
+    }
+
+    expectedFileCount := 2             // file1.txt and its copy
+    expectedByteCount := int64(22)     // The copy is wasted space
+
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    if byteCount != expectedByteCount {
+        t.Errorf("Expected byteCount: %d, Got: %d", expectedByteCount, byteCount)
+    }
+
+    if _, err := os.Stat(copyPath); err != nil {
+        t.Errorf("Expected the copy to survive a dry run: %v", err)
+    }
+
+    // The tree output leaves the duplicate report alone
+    setup()
+    os.Args = []string{"ffs", testDir, "--dupes", "--tree", "--global"}
+    main()
+
+    if fileCount != expectedFileCount {
+        t.Errorf("Expected fileCount with --tree: %d, Got: %d", expectedFileCount, fileCount)
+    }
+
+    setup()
+    os.Args = []string{"ffs", testDir, "--dupes", "--dupes-keep", "shortest", "--dupes-action", "delete", "--write", "--global"}
+    main()
+
+    if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+        t.Errorf("Expected the copy to be deleted, Got: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(testDir, "file1.txt")); err != nil {
+        t.Errorf("Expected file1.txt to be kept: %v", err)
+    }
+}
+
+func TestHashFlags(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    // sha256 of "This is a sample text."
+    listPath := filepath.Join(testDir, "known.sha256")
+    list := "# known files\n" + fmt.Sprintf("%x  file1.txt\n", sha256.Sum256([]byte("This is a sample text.")))
+    if err := ioutil.WriteFile(listPath, []byte(list), 0644); err != nil {
+        t.Fatalf("Could not create hash list: %v", err)
+    }
+
+    os.Args = []string{"ffs", testDir, "--hash-list", listPath, "--global"}
+    main()
+
+    if fileCount != 1 {
+        t.Errorf("Expected fileCount: %d, Got: %d", 1, fileCount)
+    }
+
+    // Digests computed alongside the content scan match the metadata pattern ones
+    setup()
+    os.Args = []string{"ffs", testDir, "--hash", "md5", "-m", "md5:" + fmt.Sprintf("%x", md5.Sum([]byte("This is another sample."))), "--global"}
+    main()
+
+    if fileCount != 1 || matchCount != 1 {
+        t.Errorf("Expected 1 file and 1 match, Got: %d files and %d matches", fileCount, matchCount)
+    }
+
+    sums, err := hashReader(strings.NewReader("This is another sample."), []string{"md5", "sha1"})
+    if err != nil {
+        t.Fatalf("Could not hash: %v", err)
+    }
+    if sums["sha1"] != fmt.Sprintf("%x", sha1.Sum([]byte("This is another sample."))) {
+        t.Errorf("Unexpected sha1: %s", sums["sha1"])
+    }
+
+    if _, err := parseHashAlgorithms("sha256,crc32"); err == nil {
+        t.Errorf("Expected an error for an unknown algorithm")
+    }
+}
+
+func TestIndex(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+    t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+    // Recently modified files are not indexed, age the fixtures
+    old := time.Now().Add(-time.Hour)
+    for _, name := range []string{"file1.txt", "file2.txt"} {
+        os.Chtimes(filepath.Join(testDir, name), old, old)
+    }
+
+    runIndexCommand([]string{"build", testDir})
+
+    filter := newIndexFilter(testDir, "(?i)ANOTHER")
+    if filter == nil {
+        t.Fatalf("Expected an index filter")
+    }
+    file1 := filepath.Join(testDir, "file1.txt")
+    file2 := filepath.Join(testDir, "file2.txt")
+    info1, _ := os.Lstat(file1)
+    info2, _ := os.Lstat(file2)
+    if !filter.excludes(file1, info1) || filter.excludes(file2, info2) {
+        t.Errorf("Expected only file1.txt to be excluded")
+    }
+
+    // A changed file is searched even though the index says it cannot match
+    if err := ioutil.WriteFile(file1, []byte("Yet another sample."), 0644); err != nil {
+        t.Fatalf("Could not update file1.txt: %v", err)
+    }
+    os.Args = []string{"ffs", testDir, "-s", "another", "--global"}
+    main()
+
+    if fileCount != 2 {
+        t.Errorf("Expected fileCount: %d, Got: %d", 2, fileCount)
+    }
+
+    if regexIndexQuery("foo|.*") != nil || regexIndexQuery("ab+") != nil {
+        t.Errorf("Expected patterns without required trigrams to match every file")
+    }
+}
+
+func TestMetadataCache(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+    t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+    os.Args = []string{"ffs", testDir, "--cache", "--global"}
+    main()
+
+    cache, err := openMetadataCache()
+    if err != nil {
+        t.Fatalf("Could not open cache: %v", err)
+    }
+    if len(cache.Entries) != 2 {
+        t.Fatalf("Expected 2 cache entries, Got: %d", len(cache.Entries))
+    }
+
+    // Mark the cached entries so it shows when they are used
+    for key, entry := range cache.Entries {
+        entry.Meta.Description = "from cache"
+        cache.Entries[key] = entry
+    }
+    cache.dirty = true
+    if err := cache.save(); err != nil {
+        t.Fatalf("Could not save cache: %v", err)
+    }
+
+    // Changing a file invalidates its entry
+    if err := ioutil.WriteFile(filepath.Join(testDir, "file1.txt"), []byte("Changed."), 0644); err != nil {
+        t.Fatalf("Could not update file1.txt: %v", err)
+    }
+
+    setup()
+    os.Args = []string{"ffs", testDir, "--cache", "-m", "from cache", "--global"}
+    main()
+
+    if matchCount != 1 {
+        t.Errorf("Expected matchCount: %d, Got: %d", 1, matchCount)
+    }
+
+    // Entries of deleted files are dropped by the next search of their directory
+    if err := os.Remove(filepath.Join(testDir, "file2.txt")); err != nil {
+        t.Fatalf("Could not remove file2.txt: %v", err)
+    }
+    setup()
+    os.Args = []string{"ffs", testDir, "--cache", "--global"}
+    main()
+
+    if cache, err = openMetadataCache(); err != nil {
+        t.Fatalf("Could not open cache: %v", err)
+    }
+    if len(cache.Entries) != 1 {
+        t.Errorf("Expected 1 cache entry once file2.txt is gone, Got: %d", len(cache.Entries))
+    }
+}
+
+// Create a tree of small files spread over a few directories
+func setupBenchmarkTree(b *testing.B, files int) string {
+    dir := b.TempDir()
+    for i := 0; i < files; i++ {
+        sub := filepath.Join(dir, fmt.Sprintf("dir%d", i%20))
+        os.MkdirAll(sub, 0755)
+        if err := ioutil.WriteFile(filepath.Join(sub, fmt.Sprintf("file%d.txt", i)), []byte("This is a sample text.\n"), 0644); err != nil {
+            b.Fatalf("Could not create file: %v", err)
+        }
+    }
+    return dir
+}
+
+func BenchmarkOwnerLookup(b *testing.B) {
+    uid := uint32(os.Getuid())
+
+    b.Run("os/user", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            user.LookupId(strconv.Itoa(int(uid)))
+        }
+    })
+    b.Run("memoized", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            lookupOwner(uid)
+        }
+    })
+}
+
+func BenchmarkExtractFileData(b *testing.B) {
+    dir := setupBenchmarkTree(b, 1000)
+
+    var paths []string
+    var infos []os.FileInfo
+    filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() {
+            paths = append(paths, path)
+            infos = append(infos, info)
+        }
+        return nil
+    })
+
+    extract := func(b *testing.B, useWalkerInfo bool) {
+        for i := 0; i < b.N; i++ {
+            for j, path := range paths {
+                file, err := os.Open(path)
+                if err != nil {
+                    b.Fatalf("Could not open file: %v", err)
+                }
+                if useWalkerInfo {
+                    extractFileData(file, infos[j])
+                } else {
+                    extractFileData(file, nil)
+                }
+                file.Close()
+            }
+        }
+    }
+
+    b.Run("stat", func(b *testing.B) { extract(b, false) })
+    b.Run("walker info", func(b *testing.B) { extract(b, true) })
+}
+
+func BenchmarkSearchTree(b *testing.B) {
+    dir := setupBenchmarkTree(b, 1000)
+
+    stdout := os.Stdout
+    devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+    os.Stdout = devNull
+    defer func() {
+        os.Stdout = stdout
+        devNull.Close()
+    }()
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        setup()
+        os.Args = []string{"ffs", dir, "-v", "-m", "text/plain", "--global"}
+        main()
+    }
+}
+
+func TestWatcher(t *testing.T) {
+    if runtime.GOOS != "linux" {
+        t.Skip("--watch needs inotify")
+    }
+
+    dir := t.TempDir()
+    w, err := newWatcher()
+    if err != nil {
+        t.Fatalf("Could not create watcher: %v", err)
+    }
+    if err := w.add(dir, true); err != nil {
+        t.Fatalf("Could not watch %s: %v", dir, err)
+    }
+
+    // Creating and writing a file is reported once
+    path := filepath.Join(dir, "app.log")
+    if err := ioutil.WriteFile(path, []byte("ERROR one\n"), 0644); err != nil {
+        t.Fatalf("Could not write file: %v", err)
+    }
+    paths, err := w.next()
+    if err != nil {
+        t.Fatalf("Could not read events: %v", err)
+    }
+    if len(paths) != 1 || paths[0] != path {
+        t.Errorf("Expected changed paths: [%s], Got: %v", path, paths)
+    }
+
+    // A file operand is followed by name across rotation, other files of
+    // its directory are left alone. The changes are waited for briefly, a
+    // watch lost with the old file would otherwise wait forever.
+    nextChange := func() ([]string, error) {
+        done := make(chan []string, 1)
+        go func() {
+            paths, _ := w.next()
+            done <- paths
+        }()
+        select {
+        case paths := <-done:
+            return paths, nil
+        case <-time.After(2 * time.Second):
+            return nil, fmt.Errorf("no change seen")
+        }
+    }
+    logDir := t.TempDir()
+    logPath := filepath.Join(logDir, "app.log")
+    if err := ioutil.WriteFile(logPath, []byte("ERROR one\n"), 0644); err != nil {
+        t.Fatalf("Could not write file: %v", err)
+    }
+    if err := w.add(logPath, false); err != nil {
+        t.Fatalf("Could not watch %s: %v", logPath, err)
+    }
+    if err := os.Rename(logPath, logPath+".1"); err != nil {
+        t.Fatalf("Could not rotate file: %v", err)
+    }
+    if paths, err = nextChange(); err != nil || len(paths) != 1 || paths[0] != logPath {
+        t.Errorf("Expected the rotated file to change: [%s], Got: %v %v", logPath, paths, err)
+    }
+    if err := ioutil.WriteFile(logPath, []byte("ERROR two\n"), 0644); err != nil {
+        t.Fatalf("Could not write file: %v", err)
+    }
+    if paths, err = nextChange(); err != nil || len(paths) != 1 || paths[0] != logPath {
+        t.Errorf("Expected the recreated file to change: [%s], Got: %v %v", logPath, paths, err)
+    }
+    if err := ioutil.WriteFile(logPath, []byte("ERROR two\nERROR three\n"), 0644); err != nil {
+        t.Fatalf("Could not write file: %v", err)
+    }
+    if paths, err = nextChange(); err != nil || len(paths) != 1 || paths[0] != logPath {
+        t.Errorf("Expected writes to the recreated file to be seen: [%s], Got: %v %v", logPath, paths, err)
+    }
+
+    fresh := newMatches([]Match{{Line: 1, Text: "ERROR one"}}, []Match{{Line: 1, Text: "ERROR one"}, {Line: 2, Text: "ERROR two"}})
+    if len(fresh) != 1 || fresh[0].Line != 2 {
+        t.Errorf("Expected only line 2 to be new, Got: %v", fresh)
+    }
+}
+
+func TestInteractiveBrowser(t *testing.T) {
+    keys := decodeKeys([]byte("\x1b[Bj/\x7f\x1b[6~é\r"))
+    expectedKeys := []string{"down", "j", "/", "backspace", "pgdn", "é", "enter"}
+    if strings.Join(keys, " ") != strings.Join(expectedKeys, " ") {
+        t.Errorf("Expected keys: %v, Got: %v", expectedKeys, keys)
+    }
+
+    b := &browser{rows: 24, cols: 80, results: []Result{
+        {Path: "a.go", Matches: []Match{{Line: 3, Text: "TODO fix"}, {Line: 9, Text: "TODO test"}}},
+        {Path: "b.txt", Matches: []Match{{Line: 1, Text: "TODO later"}}},
+    }}
+    b.applyFilter("")
+    for _, key := range []string{"/", "t", "e", "s", "t", "enter"} {
+        b.handle(key)
+    }
+    if len(b.visible) != 1 || b.current().Path != "a.go" {
+        t.Errorf("Expected the filter to keep only a.go, Got: %v", b.visible)
+    }
+    b.handle("n")
+    if b.focusLine() != 9 {
+        t.Errorf("Expected focus on line 9, Got: %d", b.focusLine())
+    }
+
+    // Filtering to a result with fewer matches starts at its first match
+    b.handle("esc")
+    b.handle("n")
+    b.out = bufio.NewWriter(ioutil.Discard)
+    b.applyFilter("later")
+    if b.match != 0 || b.focusLine() != 1 {
+        t.Errorf("Expected focus on the first match after filtering, Got: match %d line %d", b.match, b.focusLine())
+    }
+    b.draw()
+    b.applyFilter("test")
+    b.handle("n")
+
+    // An invalid pattern keeps the previous filter
+    b.handle("/")
+    b.handle("(")
+    if len(b.visible) != 1 || b.status == "" {
+        t.Errorf("Expected the filter to be kept with an error, Got: %v %q", b.visible, b.status)
+    }
+
+    // Matches are highlighted with the palette, and not at all without colors
+    defer func() { useColor, palette = true, defaultPalette() }()
+    useColor, palette = true, defaultPalette()
+    highlights := []*regexp.Regexp{regexp.MustCompile("TO"), regexp.MustCompile("OD")}
+    if highlighted := highlightMatches("a TODO b", highlights); highlighted != "a \x1b[1;30;43mTOD\x1b[0mO b" {
+        t.Errorf("Unexpected highlight: %q", highlighted)
+    }
+    useColor = false
+    if highlighted := highlightMatches("a TODO b", highlights); highlighted != "a TODO b" {
+        t.Errorf("Expected no highlight without colors: %q", highlighted)
+    }
+    if truncateDisplay("\x1b[7mabcdef\x1b[0m", 3) != "\x1b[7mabc\x1b[0m" {
+        t.Errorf("Unexpected truncation: %q", truncateDisplay("\x1b[7mabcdef\x1b[0m", 3))
+    }
+
+    // Rows take their colors from the palette, only the selection is reverse video
+    t.Setenv("FFS_COLORS", "fi=1;34")
+    t.Setenv("NO_COLOR", "")
+    setupColor("always")
+    listColumns, _ = parseColumns("mode,size,name")
+    defer func() { listColumns, _ = parseColumns(defaultColumns) }()
+    if row := b.listRow(b.results[1], false); !strings.HasSuffix(row, "\x1b[1;34mb.txt\x1b[0m (1)") {
+        t.Errorf("Expected the FFS_COLORS file color in the row: %q", row)
+    }
+    if row := b.listRow(b.results[1], true); strings.Count(row, "\x1b[") != 1 {
+        t.Errorf("Expected nothing but reverse video in the selection: %q", row)
+    }
+    setupColor("never")
+    if row := b.listRow(b.results[1], false); strings.Contains(row, "\x1b[") {
+        t.Errorf("Expected no colors with --color never: %q", row)
+    }
+    if row := b.listRow(b.results[1], true); !strings.HasPrefix(row, "\x1b[7m") {
+        t.Errorf("Expected the selection in reverse video with --color never: %q", row)
+    }
+}
+
+func TestTreeFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    if err := os.MkdirAll(filepath.Join(testDir, "sub"), 0755); err != nil {
+        t.Fatalf("Could not create sub directory: %v", err)
+    }
+    if err := ioutil.WriteFile(filepath.Join(testDir, "sub", "file3.txt"), []byte("A sample in a sub directory."), 0644); err != nil {
+        t.Fatalf("Could not create file3.txt: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-t", "-s", "sample", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    plain := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(buf.String(), "")
+
+    // Each directory is printed once, with the files and bytes below it
+    expected := testDir + " (3 files, 73 B)\n" +
+        "├── file1.txt (1 match)\n" +
+        "├── file2.txt (1 match)\n" +
+        "└── sub/ (1 file, 28 B)\n" +
+        "    └── file3.txt (1 match)\n"
+    if plain != expected {
+        t.Errorf("Expected tree:\n%s\nGot:\n%s", expected, plain)
+    }
+}
+
+func TestSortAndTopFlags(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    if err := ioutil.WriteFile(filepath.Join(testDir, "file3.txt"), []byte("A sample, and a sample, and a sample."), 0644); err != nil {
+        t.Fatalf("Could not create file3.txt: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--sort", "size", "--top", "2", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+
+    // Largest first, only the first two
+    expected := filepath.Join(testDir, "file3.txt") + "\n" + filepath.Join(testDir, "file2.txt") + "\n"
+    if buf.String() != expected {
+        t.Errorf("Expected output:\n%s\nGot:\n%s", expected, buf.String())
+    }
+    if fileCount != 2 {
+        t.Errorf("Expected fileCount: %d, Got: %d", 2, fileCount)
+    }
+
+    results := []Result{{Path: "b", Matches: make([]Match, 1)}, {Path: "a", Matches: make([]Match, 3)}, {Path: "c", Matches: make([]Match, 3)}}
+    sortResults(results, "matches", true)
+    if results[0].Path != "b" || results[1].Path != "c" || results[2].Path != "a" {
+        t.Errorf("Unexpected reversed order: %s %s %s", results[0].Path, results[1].Path, results[2].Path)
+    }
+}
+
+func TestStatsFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    if err := ioutil.WriteFile(filepath.Join(testDir, "notes.md"), []byte("# A sample\n\nAnother sample.\n"), 0644); err != nil {
+        t.Fatalf("Could not create notes.md: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--stats", "-s", "sample", "--format", "json", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    // The report is the last line of JSON output
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    var report struct{ Stats Stats }
+    if err := json.Unmarshal([]byte(lines[len(lines)-1]), &report); err != nil {
+        t.Fatalf("Could not decode stats: %v", err)
+    }
+
+    stats := report.Stats
+    if stats.Files != 3 || stats.Bytes != 73 || stats.Matches != 4 {
+        t.Errorf("Expected 3 files, 73 bytes and 4 matches, Got: %d files, %d bytes and %d matches", stats.Files, stats.Bytes, stats.Matches)
+    }
+    if len(stats.Extensions) != 2 || stats.Extensions[0].Name != ".txt" || stats.Extensions[0].Files != 2 {
+        t.Errorf("Unexpected extensions: %+v", stats.Extensions)
+    }
+    if stats.Sizes[1].Files != 3 {
+        t.Errorf("Expected 3 files below 1 KB, Got: %+v", stats.Sizes)
+    }
+
+    // The report counts every result, not only those --top keeps
+    setup()
+    r, w, _ = os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "--stats", "-s", "sample", "--sort", "size", "--top", "1", "--format", "json", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    buf.Reset()
+    io.Copy(&buf, r)
+    lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+    if err := json.Unmarshal([]byte(lines[len(lines)-1]), &report); err != nil {
+        t.Fatalf("Could not decode stats: %v", err)
+    }
+    if len(lines) != 2 || report.Stats.Files != 3 {
+        t.Errorf("Expected one result and stats of 3 files, Got: %d lines and %d files", len(lines), report.Stats.Files)
+    }
+
+    // A symlink counts as a file but not its size, as in the summary
+    collector := newStatsCollector()
+    collector.add(Result{Path: "link.txt", Meta: Metadata{Size: 22, Link: "file1.txt"}})
+    stats = collector.report()
+    if stats.Files != 1 || stats.Bytes != 0 || stats.Extensions[0].Bytes != 0 {
+        t.Errorf("Expected one file of 0 bytes for a symlink, Got: %d files and %d bytes", stats.Files, stats.Bytes)
+    }
+}
+
+func TestColumnsFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-v", "--columns", "size,sha1,mtime,name", "--human", "--time-format", "unix", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+
+    info, err := os.Stat(filepath.Join(testDir, "file1.txt"))
+    if err != nil {
+        t.Fatalf("Could not stat file1.txt: %v", err)
+    }
+    // Output to a pipe is not colored
+    expected := fmt.Sprintf("      22 B %x %d file1.txt", sha1.Sum([]byte("This is a sample text.")), info.ModTime().Unix())
+    if !strings.Contains(buf.String(), expected) {
+        t.Errorf("Expected row:\n%q\nGot:\n%s", expected, buf.String())
+    }
+    if strings.Contains(buf.String(), "sha1:") {
+        t.Errorf("Digest shown as a column should not also be listed below the row:\n%s", buf.String())
+    }
+
+    if _, err := parseColumns("mode,nosuch"); err == nil {
+        t.Errorf("Expected an error for an unknown column")
+    }
+
+    // Squeezing a row into a narrow terminal only shrinks flexible columns
+    columns, _ := parseColumns(defaultColumns)
+    fitColumns(columns, 100)
+    total := lastColumnWidth
+    for _, c := range columns[:len(columns)-1] {
+        total += c.width + 1
+    }
+    if total > 100 || columns[0].width != modeWidth || columns[3].width != sizeWidth {
+        t.Errorf("Unexpected widths after fitting to 100 columns: %+v", columns)
+    }
+
+    // A JPEG with a little endian Exif segment holding Model and ExposureTime
+    tiff := []byte("II*\x00\x08\x00\x00\x00" +
+        "\x02\x00" +
+        "\x10\x01\x02\x00\x06\x00\x00\x00\x26\x00\x00\x00" +
+        "\x9a\x82\x05\x00\x01\x00\x00\x00\x2c\x00\x00\x00" +
+        "\x00\x00\x00\x00" +
+        "Canon\x00" +
+        "\x04\x00\x00\x00\xe8\x03\x00\x00")
+    segment := append([]byte("Exif\x00\x00"), tiff...)
+    jpeg := append([]byte{0xff, 0xd8, 0xff, 0xe1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}, segment...)
+    jpeg = append(jpeg, 0xff, 0xda, 0x00, 0x02)
+
+    tags := parseExif(jpeg)
+    if tags["Model"] != "Canon" || tags["ExposureTime"] != "1/250" {
+        t.Errorf("Unexpected EXIF tags: %v", tags)
+    }
+}
+
+func TestTemplateFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-s", "sample", "--template", `{{relpath .Path "` + testDir + `"}}\t{{.Meta.Size}}\t{{range .Matches}}{{.Line}}{{end}}`, "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+
+    expected := "file1.txt\t22\t1\nfile2.txt\t23\t1\n"
+    if buf.String() != expected {
+        t.Errorf("Expected output:\n%q\nGot:\n%q", expected, buf.String())
+    }
+
+    templateFile := filepath.Join(t.TempDir(), "size.tmpl")
+    if err := ioutil.WriteFile(templateFile, []byte(`{{if gt .Meta.Size 22}}{{humanize .Meta.Size}} {{hex .Meta.Kind}}{{end}}`), 0644); err != nil {
+        t.Fatalf("Could not create template file: %v", err)
+    }
+    outputTemplate, _ = parseOutputTemplate("", templateFile)
+
+    r, w, _ = os.Pipe()
+    os.Stdout = w
+    printTemplate(Result{Meta: Metadata{Size: 22, Kind: "text"}})
+    printTemplate(Result{Meta: Metadata{Size: 2048, Kind: "text"}})
+    w.Close()
+    os.Stdout = oldStdout
+
+    buf.Reset()
+    io.Copy(&buf, r)
+
+    // Results the template renders empty print nothing
+    if buf.String() != "2.0 KB 74657874\n" {
+        t.Errorf("Unexpected template file output: %q", buf.String())
+    }
+
+    // The color function knows the palette keys and leaves colors out when they are off
+    defer func() { useColor, palette = true, defaultPalette() }()
+    useColor, palette = true, defaultPalette()
+    palette["nu"] = "1;34"
+    color := templateFuncs["color"].(func(string, interface{}) string)
+    if got := color("nu", 7); got != "\x1b[1;34m7\x1b[0m" {
+        t.Errorf("Expected the palette color of nu, Got: %q", got)
+    }
+    if got := color("red", "x"); got != "\x1b[31mx\x1b[0m" {
+        t.Errorf("Expected red, Got: %q", got)
+    }
+    useColor = false
+    if got := color("nu", 7); got != "7" {
+        t.Errorf("Expected no color when output is not colored, Got: %q", got)
+    }
+}
+
+func TestColorFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    run := func(args ...string) string {
+        oldStdout := os.Stdout
+        r, w, _ := os.Pipe()
+        os.Stdout = w
+
+        setup()
+        os.Args = append([]string{"ffs", testDir, "-s", "another", "-v", "--global"}, args...)
+        main()
+
+        w.Close()
+        os.Stdout = oldStdout
+
+        var buf bytes.Buffer
+        io.Copy(&buf, r)
+        return buf.String()
+    }
+
+    if output := run(); strings.Contains(output, "\x1b[") {
+        t.Errorf("Expected no colors when printing to a pipe:\n%q", output)
+    }
+
+    output := run("--color", "always")
+    if !strings.Contains(output, "\x1b[38;5;39m1\x1b[0m") {
+        t.Errorf("Expected a colored line number with --color always:\n%q", output)
+    }
+
+    t.Setenv("FFS_COLORS", "nu=1;34:di=4")
+    output = run("--color", "always")
+    if !strings.Contains(output, "\x1b[1;34m1\x1b[0m") || !strings.Contains(output, "\x1b[4m"+filepath.Clean(testDir)+"\x1b[0m") {
+        t.Errorf("Expected the FFS_COLORS palette:\n%q", output)
+    }
+
+    if err := parsePalette("nu=red"); err == nil {
+        t.Errorf("Expected an error for a palette entry which is not an SGR sequence")
+    }
+    if err := parsePalette("zz=1"); err == nil {
+        t.Errorf("Expected an error for an unknown palette key")
+    }
+
+    // NO_COLOR only turns off automatic coloring
+    t.Setenv("FFS_COLORS", "")
+    t.Setenv("NO_COLOR", "1")
+    setupColor("auto")
+    if useColor {
+        t.Errorf("Expected NO_COLOR to turn colors off")
+    }
+    setupColor("always")
+    if !useColor {
+        t.Errorf("Expected --color always to override NO_COLOR")
+    }
+}
+
+func TestSarifFormat(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    rulesFile := filepath.Join(t.TempDir(), "rules")
+    if err := ioutil.WriteFile(rulesFile, []byte("# Words to report\nanother=another\ntext=(?i)TEXT\n"), 0644); err != nil {
+        t.Fatalf("Could not create rules file: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-s", "sample", "--rules", rulesFile, "--format", "sarif", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var log sarifLog
+    if err := json.NewDecoder(r).Decode(&log); err != nil {
+        t.Fatalf("Could not decode SARIF output: %v", err)
+    }
+    if log.Version != "2.1.0" || len(log.Runs) != 1 {
+        t.Fatalf("Unexpected SARIF log: %+v", log)
+    }
+
+    rules := log.Runs[0].Tool.Driver.Rules
+    if len(rules) != 3 || rules[0].ID != "pattern" || rules[1].ID != "another" || rules[2].ID != "text" {
+        t.Errorf("Unexpected rules: %+v", rules)
+    }
+
+    // Each line is reported once for every rule it matches
+    var found []string
+    for _, result := range log.Runs[0].Results {
+        location := result.Locations[0].PhysicalLocation
+        found = append(found, fmt.Sprintf("%s %s %d:%d", result.RuleID, filepath.Base(location.ArtifactLocation.URI), location.Region.StartLine, location.Region.StartColumn))
+    }
+    expected := []string{"pattern file1.txt 1:11", "text file1.txt 1:18", "pattern file2.txt 1:17", "another file2.txt 1:9"}
+    if strings.Join(found, ", ") != strings.Join(expected, ", ") {
+        t.Errorf("Expected results: %v, Got: %v", expected, found)
+    }
+
+    // Match columns count bytes, SARIF columns count characters
+    if column := matchColumn(regexp.MustCompile("b"), "ééb"); column != 5 {
+        t.Errorf("Expected match columns to count bytes, Got: %d", column)
+    }
+    multibyte := buildSarif([]Result{{Path: "x", Matches: []Match{{Line: 1, Column: 5, Text: "ééb"}}}}, []contentRule{{ID: "b", Regex: regexp.MustCompile("b")}})
+    if column := multibyte.Runs[0].Results[0].Locations[0].PhysicalLocation.Region.StartColumn; column != 3 {
+        t.Errorf("Expected SARIF columns to count characters, Got: %d", column)
+    }
+}
+
+func TestVimgrepFlag(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    if err := ioutil.WriteFile(filepath.Join(testDir, "file3.txt"), []byte("no match\n\té sample, sample\n"), 0644); err != nil {
+        t.Fatalf("Could not create file3.txt: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-s", "sample", "--vimgrep", "--color", "always", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+
+    // Every occurrence, uncolored, with columns counted in bytes so the
+    // two byte é moves the columns after it along by two
+    file3 := filepath.Join(testDir, "file3.txt")
+    expected := filepath.Join(testDir, "file1.txt") + ":1:11:This is a sample text.\n" +
+        filepath.Join(testDir, "file2.txt") + ":1:17:This is another sample.\n" +
+        file3 + ":2:5:\té sample, sample\n" +
+        file3 + ":2:13:\té sample, sample\n"
+    if buf.String() != expected {
+        t.Errorf("Expected output:\n%q\nGot:\n%q", expected, buf.String())
+    }
+
+    // What cannot be printed is masked byte for byte, so the column still
+    // points at the match in the printed text
+    r, w, _ = os.Pipe()
+    os.Stdout = w
+    printVimgrep(Result{Path: "x", Matches: []Match{{Line: 1, Text: "\u200b\xff sample"}}}, regexp.MustCompile("sample"), false)
+    w.Close()
+    os.Stdout = oldStdout
+
+    buf.Reset()
+    io.Copy(&buf, r)
+    if buf.String() != "x:1:6:.... sample\n" {
+        t.Errorf("Unexpected masked output: %q", buf.String())
+    }
+}
+
+func TestHTMLFormat(t *testing.T) {
+    setup()
+
+    testDir := setupTestFiles(t)
+    defer os.RemoveAll(testDir)
+
+    if err := os.MkdirAll(filepath.Join(testDir, "sub"), 0755); err != nil {
+        t.Fatalf("Could not create sub: %v", err)
+    }
+    if err := ioutil.WriteFile(filepath.Join(testDir, "sub", "page.html"), []byte("<b>sample</b> & more\n"), 0644); err != nil {
+        t.Fatalf("Could not create page.html: %v", err)
+    }
+
+    oldStdout := os.Stdout
+    r, w, _ := os.Pipe()
+    os.Stdout = w
+
+    os.Args = []string{"ffs", testDir, "-s", "sample", "--format", "html", "--global"}
+    main()
+
+    w.Close()
+    os.Stdout = oldStdout
+
+    var buf bytes.Buffer
+    io.Copy(&buf, r)
+    output := buf.String()
+
+    for _, expected := range []string{
+        "<!DOCTYPE html>",
+        "<tr><td>Files</td><td>3</td></tr>",
+        "<tr><td>Matches</td><td>3</td></tr>",
+        // Directories are collapsible with the totals below them
+        "<summary>sub <span class=\"muted\">(1 file, 21 B)</span></summary>",
+        // Matched text is escaped and highlighted
+        "&lt;b&gt;<mark>sample</mark>&lt;/b&gt; &amp; more",
+        "<td class=\"num\" data-sort=\"22\">22 B</td>",
+    } {
+        if !strings.Contains(output, expected) {
+            t.Errorf("Expected report to contain %q", expected)
+        }
+    }
+    if strings.Contains(output, "<b>sample") {
+        t.Errorf("Matched text should be escaped")
+    }
+
+    segments := highlightSegments("a sample, a sample", regexp.MustCompile("sample"))
+    if len(segments) != 4 || !segments[1].Hit || segments[2].Text != ", a " || !segments[3].Hit {
+        t.Errorf("Unexpected segments: %+v", segments)
+    }
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Supported digest algorithms
+var hashConstructors = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Algorithm implied by the length of a hex digest in a hash list
+var hashLengths = map[int]string{
+	md5.Size * 2:    "md5",
+	sha1.Size * 2:   "sha1",
+	sha256.Size * 2: "sha256",
+	sha512.Size * 2: "sha512",
+}
+
+// multiHash computes several digests of a stream in one pass
+type multiHash struct {
+	names  []string
+	hashes []hash.Hash
+	io.Writer
+}
+
+func newMultiHash(names []string) *multiHash {
+	h := &multiHash{names: names}
+	writers := make([]io.Writer, len(names))
+	for i, name := range names {
+		h.hashes = append(h.hashes, hashConstructors[name]())
+		writers[i] = h.hashes[i]
+	}
+	h.Writer = io.MultiWriter(writers...)
+	return h
+}
+
+// Hex encoded digests keyed by algorithm name
+func (h *multiHash) sums() map[string]string {
+	sums := make(map[string]string, len(h.names))
+	for i, name := range h.names {
+		sums[name] = hex.EncodeToString(h.hashes[i].Sum(nil))
+	}
+	return sums
+}
+
+// Utility function to parse a comma separated list of algorithms
+func parseHashAlgorithms(spec string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if hashConstructors[name] == nil {
+			return nil, fmt.Errorf("unknown hash algorithm %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load known hashes, one per line with anything after the first field ignored
+// so sha256sum style output can be used directly. Returns the set of hashes
+// and the algorithms needed to check them.
+func loadHashList(path string) (map[string]bool, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	list := make(map[string]bool)
+	needed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		digest := strings.ToLower(fields[0])
+		name, ok := hashLengths[len(digest)]
+		if _, err := hex.DecodeString(digest); err != nil || !ok {
+			return nil, nil, fmt.Errorf("%s:%d: not an md5, sha1, sha256 or sha512 digest", path, lineNumber)
+		}
+		list[digest] = true
+		needed[name] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	for name := range needed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return list, names, nil
+}
+
+// Check whether any digest of a file is in the list
+func matchHashList(sums map[string]string, list map[string]bool) bool {
+	for _, sum := range sums {
+		if list[sum] {
+			return true
+		}
+	}
+	return false
+}
+
+// Utility function to format digests as name:hex pairs in algorithm order
+func formatHashes(sums map[string]string) []string {
+	var pairs []string
+	for name, sum := range sums {
+		pairs = append(pairs, name+":"+sum)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Compute the digests of everything read from r
+func hashReader(r io.Reader, names []string) (map[string]string, error) {
+	h := newMultiHash(names)
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.sums(), nil
+}
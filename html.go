@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlReport is what the --format html template renders
+type htmlReport struct {
+	Root      string
+	Command   string
+	Generated string
+	Files     int
+	Bytes     int64
+	Matches   int
+	Tree      htmlTreeNode
+	Rows      []htmlRow
+}
+
+// htmlTreeNode is a directory, or a file linking to its table row, of the tree
+type htmlTreeNode struct {
+	Name     string
+	Files    int
+	Bytes    int64
+	Anchor   string
+	Link     string
+	Matches  int
+	Children []htmlTreeNode
+}
+
+// htmlRow is a file of the metadata table, with its highlighted matches
+type htmlRow struct {
+	Anchor  string
+	Result  Result
+	ModTime time.Time
+	Flags   []string
+	Exif    []string
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Line     int
+	Segments []htmlSegment
+}
+
+// htmlSegment is a run of a matched line, Hit when the pattern matched it
+type htmlSegment struct {
+	Text string
+	Hit  bool
+}
+
+// Split a line into the runs the pattern matched and those between them
+func highlightSegments(line string, pattern *regexp.Regexp) []htmlSegment {
+	var segments []htmlSegment
+	last := 0
+	if pattern != nil {
+		for _, loc := range pattern.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if loc[0] > last {
+				segments = append(segments, htmlSegment{Text: line[last:loc[0]]})
+			}
+			segments = append(segments, htmlSegment{Text: line[loc[0]:loc[1]], Hit: true})
+			last = loc[1]
+		}
+	}
+	if last < len(line) || len(segments) == 0 {
+		segments = append(segments, htmlSegment{Text: line[last:]})
+	}
+	return segments
+}
+
+// Convert the result hierarchy of the tree output for the template
+func htmlTree(node *treeNode, anchors map[*Result]string) htmlTreeNode {
+	out := htmlTreeNode{Name: node.name, Files: node.files, Bytes: node.size}
+	if node.result != nil {
+		out.Anchor = anchors[node.result]
+		out.Link = node.result.Meta.Link
+		out.Matches = len(node.result.Matches)
+		return out
+	}
+
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out.Children = append(out.Children, htmlTree(node.children[name], anchors))
+	}
+	return out
+}
+
+// Build the report of the results, highlighting what pattern matched
+func buildHTMLReport(root string, results []Result, pattern *regexp.Regexp, matches int) htmlReport {
+	report := htmlReport{
+		Root:      root,
+		Command:   strings.Join(os.Args, " "),
+		Generated: time.Now().Format("2006-01-02 15:04:05"),
+		Files:     len(results),
+		Matches:   matches,
+	}
+
+	anchors := make(map[*Result]string)
+	for i := range results {
+		result := &results[i]
+		if result.Meta.Link == "" {
+			report.Bytes += result.Meta.Size
+		}
+
+		row := htmlRow{Anchor: fmt.Sprintf("f%d", i), Result: *result, ModTime: resultModTime(*result)}
+		anchors[result] = row.Anchor
+		if result.Meta.Suid {
+			row.Flags = append(row.Flags, "SUID")
+		}
+		if result.Meta.Capabilities != "" {
+			row.Flags = append(row.Flags, "caps: "+result.Meta.Capabilities)
+		}
+		if result.Meta.ACL != "" {
+			row.Flags = append(row.Flags, "ACL")
+		}
+		for tag, value := range result.Meta.Exif {
+			row.Exif = append(row.Exif, tag+"="+value)
+		}
+		sort.Strings(row.Exif)
+
+		// The text of binary files is not worth showing
+		if !(binaryMatches && result.Meta.Kind == kindBinary) {
+			for _, match := range result.Matches {
+				row.Lines = append(row.Lines, htmlLine{Line: match.Line, Segments: highlightSegments(replaceNonPrintableKeepTabs(match.Text), pattern)})
+			}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	report.Tree = htmlTree(buildTree(root, results), anchors)
+	return report
+}
+
+// Print the results as a single HTML page which needs nothing else to view
+func printHTML(root string, results []Result, pattern *regexp.Regexp, matches int) {
+	if err := htmlTemplate.Execute(os.Stdout, buildHTMLReport(filepath.Clean(root), results, pattern, matches)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"humanize": humanizeBytes}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>ffs report of {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+code, pre, td.mono { font-family: monospace; }
+.summary td { padding: 0 1em 0 0; }
+.tree ul { list-style: none; padding-left: 1.2em; margin: 0; }
+.tree summary { cursor: pointer; color: #2a7a2a; }
+.muted { color: #888; }
+table.files { border-collapse: collapse; width: 100%; }
+table.files th, table.files td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+table.files th { cursor: pointer; background: #f4f4f4; user-select: none; }
+table.files td.num { text-align: right; }
+.flag { background: #c0392b; color: #fff; border-radius: 3px; padding: 0 0.3em; font-size: 0.85em; }
+.file { margin-top: 1.5em; }
+.file pre { background: #f8f8f8; padding: 0.5em; overflow-x: auto; margin: 0.3em 0; }
+.line { color: #2b6cb0; display: inline-block; min-width: 4em; }
+mark { background: #ffe066; }
+</style>
+</head>
+<body>
+<h1>ffs report of {{.Root}}</h1>
+<table class="summary">
+<tr><td>Files</td><td>{{.Files}}</td></tr>
+<tr><td>Bytes</td><td>{{.Bytes}} ({{humanize .Bytes}})</td></tr>
+<tr><td>Matches</td><td>{{.Matches}}</td></tr>
+<tr><td>Command</td><td><code>{{.Command}}</code></td></tr>
+<tr><td>Generated</td><td>{{.Generated}}</td></tr>
+</table>
+
+<h2>Tree</h2>
+<div class="tree"><ul>
+{{template "node" .Tree}}
+</ul></div>
+
+<h2>Files</h2>
+<table class="files" id="files">
+<thead><tr><th>Path</th><th>Mode</th><th>Owner</th><th>Group</th><th>Size</th><th>Modified</th><th>Type</th><th>Flags</th><th>EXIF</th><th>Matches</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr id="row-{{.Anchor}}">
+<td class="mono">{{if .Result.Matches}}<a href="#{{.Anchor}}">{{.Result.Path}}</a>{{else}}{{.Result.Path}}{{end}}{{with .Result.Meta.Link}} <span class="muted">--&gt; {{.}}</span>{{end}}</td>
+<td class="mono">{{.Result.Meta.Mode}}</td>
+<td>{{.Result.Meta.Owner}}</td>
+<td>{{.Result.Meta.Group}}</td>
+<td class="num" data-sort="{{.Result.Meta.Size}}">{{humanize .Result.Meta.Size}}</td>
+<td data-sort="{{.ModTime.Unix}}">{{.Result.Meta.ModTime}}</td>
+<td>{{.Result.Meta.MimeType}}</td>
+<td>{{range .Flags}}<span class="flag">{{.}}</span> {{end}}</td>
+<td>{{range .Exif}}{{.}}<br>{{end}}</td>
+<td class="num" data-sort="{{len .Result.Matches}}">{{len .Result.Matches}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+
+<h2>Matches</h2>
+{{range .Rows}}{{if .Result.Matches}}<div class="file" id="{{.Anchor}}">
+<code>{{.Result.Path}}</code> <span class="muted">{{.Result.Meta.Mode}} {{.Result.Meta.Owner}} {{humanize .Result.Meta.Size}} {{.Result.Meta.MimeType}}</span>
+{{if .Lines}}<pre>{{range .Lines}}<span class="line">{{.Line}}</span>{{range .Segments}}{{if .Hit}}<mark>{{.Text}}</mark>{{else}}{{.Text}}{{end}}{{end}}
+{{end}}</pre>{{else}}<p class="muted">Binary file matches</p>{{end}}
+</div>
+{{end}}{{end}}
+
+<script>
+// Sort the files table by the clicked column, clicking again reverses it
+document.querySelectorAll("#files th").forEach(function (th, column) {
+	th.addEventListener("click", function () {
+		var tbody = document.querySelector("#files tbody");
+		var rows = Array.prototype.slice.call(tbody.rows);
+		var ascending = th.dataset.order !== "asc";
+		th.dataset.order = ascending ? "asc" : "desc";
+		var key = function (row) {
+			var cell = row.cells[column];
+			return cell.dataset.sort !== undefined ? Number(cell.dataset.sort) : cell.textContent.toLowerCase();
+		};
+		rows.sort(function (a, b) {
+			var x = key(a), y = key(b);
+			return (x < y ? -1 : x > y ? 1 : 0) * (ascending ? 1 : -1);
+		});
+		rows.forEach(function (row) { tbody.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+{{define "node"}}{{if .Anchor}}<li><a href="#row-{{.Anchor}}">{{.Name}}</a>{{with .Link}} <span class="muted">--&gt; {{.}}</span>{{end}}{{if .Matches}} <span class="muted">({{.Matches}} {{if eq .Matches 1}}match{{else}}matches{{end}})</span>{{end}}</li>{{else}}<li><details open><summary>{{.Name}} <span class="muted">({{.Files}} {{if eq .Files 1}}file{{else}}files{{end}}, {{humanize .Bytes}})</span></summary><ul>{{range .Children}}{{template "node" .}}{{end}}</ul></details></li>{{end}}{{end}}`))
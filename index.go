@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sabhiram/go-gitignore"
+)
+
+// Bumped whenever the on-disk layout changes, older indexes are rebuilt
+const indexVersion = 1
+
+// Files larger than this are left out of the index and always scanned
+const indexMaxFileSize = 256 * 1024 * 1024
+
+// indexEntry records the state of a file when it was indexed. Files which
+// are not Indexed, such as binaries or UTF-16 text, are always scanned.
+type indexEntry struct {
+	Path    string
+	Size    int64
+	ModTime int64
+	Indexed bool
+}
+
+// trigramIndex maps every trigram of the lowercased content of the files
+// below Root to the sorted ids of the files containing it
+type trigramIndex struct {
+	Version  int
+	Root     string
+	Built    time.Time
+	Files    []indexEntry
+	Postings map[uint32][]uint32
+
+	byPath map[string]uint32
+}
+
+// Directory where ffs keeps data between runs
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ffs"), nil
+}
+
+// Location of the index for a root directory
+func indexPath(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, "index", hex.EncodeToString(sum[:8])+".gob"), nil
+}
+
+// Load the index of a root directory, returning an error if there is none
+func loadIndex(root string) (*trigramIndex, error) {
+	path, err := indexPath(root)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var idx trigramIndex
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("index of %s is from another version of ffs", root)
+	}
+	idx.byPath = make(map[string]uint32, len(idx.Files))
+	for id, entry := range idx.Files {
+		idx.byPath[entry.Path] = uint32(id)
+	}
+	return &idx, nil
+}
+
+// Write the index next to its final location and rename it into place
+func (idx *trigramIndex) save() error {
+	path, err := indexPath(idx.Root)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(idx)
+	}, nil)
+}
+
+// Build a new index of root, reusing the postings of files which have not
+// changed since old was built. The files are those a search visits by
+// default, so .gitignore is honoured and .git directories are skipped.
+func updateIndex(root string, old *trigramIndex, errors bool) (*trigramIndex, int, error) {
+	idx := &trigramIndex{Version: indexVersion, Root: root, Built: time.Now(), Postings: make(map[uint32][]uint32)}
+
+	var ignoreParser ignore.IgnoreParser
+	if _, err := os.Stat(filepath.Join(root, ".gitignore")); err == nil {
+		ignoreParser, err = ignore.CompileIgnoreFile(filepath.Join(root, ".gitignore"))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Ids of unchanged files in the old index, mapped to their new ids
+	remap := make(map[uint32]uint32)
+	reused := 0
+
+	err := Walk(root, false, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors {
+				fmt.Printf("Error processing file %s: %v\n", path, err)
+			}
+			return nil
+		}
+		if info.IsDir() || (ignoreParser != nil && ignoreParser.MatchesPath(path)) || strings.Contains(path, ".git") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+
+		entry := indexEntry{Path: rel, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		id := uint32(len(idx.Files))
+
+		if old != nil {
+			if oldID, ok := old.byPath[rel]; ok && old.Files[oldID].Indexed && old.fresh(oldID, info) {
+				entry.Indexed = true
+				remap[oldID] = id
+				reused++
+				idx.Files = append(idx.Files, entry)
+				return nil
+			}
+		}
+
+		// Files changed so recently that a later change could keep the same
+		// mtime are left unindexed, much like git's racy timestamps
+		if info.Mode().IsRegular() && info.Size() <= indexMaxFileSize && info.ModTime().Before(idx.Built.Add(-time.Second)) {
+			trigrams, ok, err := fileTrigrams(path)
+			if err != nil && errors {
+				fmt.Printf("Error indexing file %s: %v\n", path, err)
+			}
+			if ok && err == nil {
+				entry.Indexed = true
+				for _, t := range trigrams {
+					idx.Postings[t] = append(idx.Postings[t], id)
+				}
+			}
+		}
+		idx.Files = append(idx.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Carry over the postings of unchanged files, keeping each list sorted
+	if old != nil {
+		for t, ids := range old.Postings {
+			var kept []uint32
+			for _, oldID := range ids {
+				if id, ok := remap[oldID]; ok {
+					kept = append(kept, id)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			merged := append(idx.Postings[t], kept...)
+			sort.Slice(merged, func(i, j int) bool { return merged[i] < merged[j] })
+			idx.Postings[t] = merged
+		}
+	}
+
+	idx.byPath = make(map[string]uint32, len(idx.Files))
+	for id, entry := range idx.Files {
+		idx.byPath[entry.Path] = uint32(id)
+	}
+	return idx, reused, nil
+}
+
+// Check a file still has the size and mtime it had when indexed
+func (idx *trigramIndex) fresh(id uint32, info os.FileInfo) bool {
+	entry := idx.Files[id]
+	return info.Mode().IsRegular() && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano()
+}
+
+// Collect the distinct trigrams of a UTF-8 text file with ASCII letters
+// lowercased. Returns false for files which are not indexed.
+func fileTrigrams(path string) ([]uint32, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, sniffSize)
+	sample, _ := reader.Peek(classifySampleSize)
+	encoding := detectEncoding(sample)
+	if encoding != encodingUTF8 || classifyContent(sample, http.DetectContentType(sample), encoding) == kindBinary {
+		return nil, false, nil
+	}
+
+	seen := make(map[uint32]bool)
+	var t uint32
+	n := 0
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		t = (t<<8 | uint32(lowerASCII(b))) & 0xffffff
+		n++
+		if n >= 3 {
+			seen[t] = true
+		}
+	}
+
+	trigrams := make([]uint32, 0, len(seen))
+	for t := range seen {
+		trigrams = append(trigrams, t)
+	}
+	return trigrams, true, nil
+}
+
+func lowerASCII(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// indexQuery is a boolean query over trigrams. A nil query matches every file.
+type indexQuery struct {
+	op      byte // '&' for all of subs, '|' for any of subs, 't' for trigram
+	trigram uint32
+	subs    []*indexQuery
+}
+
+// Work out which trigrams a line must contain to match a regex. The query
+// may match more files than the regex does but never fewer.
+func regexIndexQuery(pattern string) *indexQuery {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return syntaxQuery(re.Simplify())
+}
+
+func syntaxQuery(re *syntax.Regexp) *indexQuery {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return literalQuery(string(re.Rune), re.Flags&syntax.FoldCase != 0)
+	case syntax.OpCapture, syntax.OpPlus:
+		return syntaxQuery(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return syntaxQuery(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Join neighbouring literals so trigrams spanning them are used too
+		var subs []*indexQuery
+		for i := 0; i < len(re.Sub); i++ {
+			if re.Sub[i].Op != syntax.OpLiteral {
+				subs = append(subs, syntaxQuery(re.Sub[i]))
+				continue
+			}
+			literal := ""
+			fold := false
+			for ; i < len(re.Sub) && re.Sub[i].Op == syntax.OpLiteral; i++ {
+				literal += string(re.Sub[i].Rune)
+				fold = fold || re.Sub[i].Flags&syntax.FoldCase != 0
+			}
+			i--
+			subs = append(subs, literalQuery(literal, fold))
+		}
+		return andQuery(subs)
+	case syntax.OpAlternate:
+		var subs []*indexQuery
+		for _, sub := range re.Sub {
+			q := syntaxQuery(sub)
+			if q == nil {
+				return nil
+			}
+			subs = append(subs, q)
+		}
+		return &indexQuery{op: '|', subs: subs}
+	}
+	return nil
+}
+
+// Query for the trigrams of a literal. Case folded literals skip trigrams
+// with letters whose folds are not ASCII, like the Kelvin sign for k.
+func literalQuery(literal string, fold bool) *indexQuery {
+	var subs []*indexQuery
+	for i := 0; i+3 <= len(literal); i++ {
+		tri := literal[i : i+3]
+		if fold && (strings.ContainsAny(strings.ToLower(tri), "ks") || !isASCII(tri)) {
+			continue
+		}
+		t := uint32(lowerASCII(tri[0]))<<16 | uint32(lowerASCII(tri[1]))<<8 | uint32(lowerASCII(tri[2]))
+		subs = append(subs, &indexQuery{op: 't', trigram: t})
+	}
+	return andQuery(subs)
+}
+
+func andQuery(subs []*indexQuery) *indexQuery {
+	var kept []*indexQuery
+	for _, sub := range subs {
+		if sub != nil {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return &indexQuery{op: '&', subs: kept}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Ids of the indexed files which may match the query, in order
+func (idx *trigramIndex) evaluate(q *indexQuery) []uint32 {
+	switch q.op {
+	case 't':
+		return idx.Postings[q.trigram]
+	case '&':
+		ids := idx.evaluate(q.subs[0])
+		for _, sub := range q.subs[1:] {
+			if len(ids) == 0 {
+				break
+			}
+			ids = intersectIDs(ids, idx.evaluate(sub))
+		}
+		return ids
+	default:
+		var ids []uint32
+		for _, sub := range q.subs {
+			ids = unionIDs(ids, idx.evaluate(sub))
+		}
+		return ids
+	}
+}
+
+func intersectIDs(a []uint32, b []uint32) []uint32 {
+	var ids []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ids = append(ids, a[i])
+			i++
+			j++
+		}
+	}
+	return ids
+}
+
+func unionIDs(a []uint32, b []uint32) []uint32 {
+	ids := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			ids = append(ids, a[i])
+			i++
+		case a[i] > b[j]:
+			ids = append(ids, b[j])
+			j++
+		default:
+			ids = append(ids, a[i])
+			i++
+			j++
+		}
+	}
+	ids = append(ids, a[i:]...)
+	return append(ids, b[j:]...)
+}
+
+// indexFilter skips files which the index shows cannot match a pattern
+type indexFilter struct {
+	idx        *trigramIndex
+	root       string
+	candidates map[uint32]bool
+}
+
+// Prepare to narrow a search of root for pattern using its index. Returns
+// nil when there is no index or the pattern has no trigrams to look up.
+func newIndexFilter(root string, pattern string) *indexFilter {
+	q := regexIndexQuery(pattern)
+	if q == nil {
+		return nil
+	}
+	idx, err := loadIndex(root)
+	if err != nil {
+		return nil
+	}
+	filter := &indexFilter{idx: idx, root: root, candidates: make(map[uint32]bool)}
+	for _, id := range idx.evaluate(q) {
+		filter.candidates[id] = true
+	}
+	return filter
+}
+
+// Check whether a file can be skipped, anything changed since the index was
+// built or never indexed is searched as usual
+func (filter *indexFilter) excludes(path string, info os.FileInfo) bool {
+	rel, err := filepath.Rel(filter.root, path)
+	if err != nil {
+		return false
+	}
+	id, ok := filter.idx.byPath[rel]
+	if !ok || !filter.idx.Files[id].Indexed || !filter.idx.fresh(id, info) {
+		return false
+	}
+	return !filter.candidates[id]
+}
+
+// Run ffs index build|update [ROOT]
+func runIndexCommand(args []string) {
+	if len(args) < 1 || len(args) > 2 || (args[0] != "build" && args[0] != "update") {
+		fmt.Printf("Usage: ffs index build|update [ROOT]\n")
+		os.Exit(1)
+	}
+	root := "."
+	if len(args) == 2 {
+		homedir, _ := os.UserHomeDir()
+		root = strings.Replace(args[1], "~", homedir, 1)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		fmt.Printf("Error: '%s' is not a directory.\n", root)
+		os.Exit(1)
+	}
+	if err := setupColor("auto"); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var old *trigramIndex
+	if args[0] == "update" {
+		old, _ = loadIndex(root)
+	}
+
+	start := time.Now()
+	idx, reused, err := updateIndex(root, old, true)
+	if err != nil {
+		fmt.Printf("Error indexing %s: %v\n", root, err)
+		os.Exit(1)
+	}
+	if err := idx.save(); err != nil {
+		fmt.Printf("Error saving index: %v\n", err)
+		os.Exit(1)
+	}
+
+	indexed := 0
+	for _, entry := range idx.Files {
+		if entry.Indexed {
+			indexed++
+		}
+	}
+	fmt.Println(colorize("hd", "- files:"), len(idx.Files))
+	fmt.Printf("%s %d (%d unchanged)\n", colorize("hd", "- indexed:"), indexed, reused)
+	fmt.Println(colorize("hd", "- trigrams:"), len(idx.Postings))
+	fmt.Printf("%s %s\n", colorize("hd", "- time:"), time.Since(start).Round(time.Millisecond))
+}
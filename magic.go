@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// magicEntry describes a file signature: the bytes expected at an offset,
+// an optional mask applied before comparing, an optional check of the rest
+// of the header for signatures too short to trust, and what a match means
+type magicEntry struct {
+	Offset      int
+	SkipSpace   bool
+	Bytes       []byte
+	Mask        []byte
+	Check       func(buf []byte) bool
+	MimeType    string
+	Description string
+}
+
+// Built in signatures for formats http.DetectContentType does not know about.
+// More specific entries must come before the generic ones they overlap with.
+var builtinMagicTable = []magicEntry{
+	{Offset: 0, Bytes: []byte("\x7fELF"), MimeType: "application/x-elf", Description: "ELF executable"},
+	{Offset: 0, Bytes: []byte("\xfe\xed\xfa\xce"), Mask: []byte("\xff\xff\xff\xfe"), MimeType: "application/x-mach-binary", Description: "Mach-O executable (big endian)"},
+	{Offset: 0, Bytes: []byte("\xce\xfa\xed\xfe"), Mask: []byte("\xfe\xff\xff\xff"), MimeType: "application/x-mach-binary", Description: "Mach-O executable"},
+	// Fat binaries and Java classes share a magic, fat headers have a small arch count where classes have their version
+	{Offset: 0, Bytes: []byte("\xca\xfe\xba\xbe\x00\x00\x00\x00"), Mask: []byte("\xff\xff\xff\xff\xff\xff\xff\xe0"), MimeType: "application/x-mach-binary", Description: "Mach-O universal binary"},
+	{Offset: 0, Bytes: []byte("\xca\xfe\xba\xbe"), MimeType: "application/java-vm", Description: "compiled Java class data"},
+	{Offset: 0, Bytes: []byte("MZ"), Check: hasPEHeader, MimeType: "application/vnd.microsoft.portable-executable", Description: "PE executable"},
+	{Offset: 0, Bytes: []byte("#!"), MimeType: "text/x-shellscript", Description: "script with shebang"},
+	{Offset: 0, Bytes: []byte("SQLite format 3\x00"), MimeType: "application/vnd.sqlite3", Description: "SQLite 3 database"},
+	{Offset: 0, SkipSpace: true, Bytes: []byte("%PDF-"), MimeType: "application/pdf", Description: "PDF document"},
+	{Offset: 0, Bytes: []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), MimeType: "application/x-ole-storage", Description: "Microsoft Office document (OLE2)"},
+	{Offset: 30, Bytes: []byte("[Content_Types].xml"), MimeType: "application/vnd.openxmlformats-officedocument", Description: "Microsoft Office Open XML document"},
+	{Offset: 30, Bytes: []byte("mimetypeapplication/vnd.oasis.opendocument."), MimeType: "application/vnd.oasis.opendocument", Description: "OpenDocument file"},
+	{Offset: 0, Bytes: []byte("PK\x03\x04"), MimeType: "application/zip", Description: "Zip archive"},
+	{Offset: 0, Bytes: []byte("\x1f\x8b"), MimeType: "application/x-gzip", Description: "gzip compressed data"},
+	{Offset: 0, Bytes: []byte("BZh"), MimeType: "application/x-bzip2", Description: "bzip2 compressed data"},
+	{Offset: 0, Bytes: []byte("\xfd7zXZ\x00"), MimeType: "application/x-xz", Description: "XZ compressed data"},
+	{Offset: 0, Bytes: []byte("\x28\xb5\x2f\xfd"), MimeType: "application/zstd", Description: "Zstandard compressed data"},
+	{Offset: 0, Bytes: []byte("7z\xbc\xaf\x27\x1c"), MimeType: "application/x-7z-compressed", Description: "7-zip archive"},
+	{Offset: 257, Bytes: []byte("ustar"), MimeType: "application/x-tar", Description: "POSIX tar archive"},
+	{Offset: 0, Bytes: []byte("QFI\xfb"), MimeType: "application/x-qemu-disk", Description: "QEMU QCOW disk image"},
+	{Offset: 0, Bytes: []byte("KDMV"), MimeType: "application/x-vmdk", Description: "VMware VMDK disk image"},
+	{Offset: 0, Bytes: []byte("conectix"), MimeType: "application/x-vhd", Description: "Microsoft VHD disk image"},
+	{Offset: 0, Bytes: []byte("vhdxfile"), MimeType: "application/x-vhdx", Description: "Microsoft VHDX disk image"},
+	{Offset: 32769, Bytes: []byte("CD001"), MimeType: "application/x-iso9660-image", Description: "ISO 9660 CD-ROM filesystem"},
+	{Offset: 510, Bytes: []byte("\x55\xaa"), MimeType: "application/x-raw-disk-image", Description: "DOS/MBR boot sector"},
+	{Offset: 0, Bytes: []byte("\x00asm"), MimeType: "application/wasm", Description: "WebAssembly binary"},
+	{Offset: 0, Bytes: []byte("dex\n"), MimeType: "application/vnd.android.dex", Description: "Android Dalvik executable"},
+	{Offset: 0, Bytes: []byte("-----BEGIN "), MimeType: "application/x-pem-file", Description: "PEM encoded data"},
+	{Offset: 0, Bytes: []byte("openssh-key-v1\x00"), MimeType: "application/x-openssh-key", Description: "OpenSSH private key"},
+	{Offset: 0, Bytes: []byte("\xd4\xc3\xb2\xa1"), MimeType: "application/vnd.tcpdump.pcap", Description: "pcap capture file"},
+	{Offset: 0, Bytes: []byte("\x0a\x0d\x0d\x0a"), MimeType: "application/x-pcapng", Description: "pcapng capture file"},
+}
+
+// Signatures in use, the built in table optionally preceded by user entries
+var magicTable = builtinMagicTable
+
+// Test whether the entry matches the buffer
+func (entry magicEntry) match(buf []byte) bool {
+	offset := entry.Offset
+	if entry.SkipSpace {
+		for offset < len(buf) && (buf[offset] == ' ' || buf[offset] == '\t' || buf[offset] == '\r' || buf[offset] == '\n' || buf[offset] == '\f') {
+			offset++
+		}
+	}
+	if offset+len(entry.Bytes) > len(buf) {
+		return false
+	}
+	data := buf[offset : offset+len(entry.Bytes)]
+	if entry.Mask == nil {
+		if !bytes.Equal(data, entry.Bytes) {
+			return false
+		}
+	} else {
+		for i, b := range data {
+			if b&entry.Mask[i] != entry.Bytes[i]&entry.Mask[i] {
+				return false
+			}
+		}
+	}
+	return entry.Check == nil || entry.Check(buf)
+}
+
+// Test whether an MZ header points at a PE header. Text may well start
+// with MZ, the PE signature at the offset stored at 0x3c is what tells an
+// executable apart.
+func hasPEHeader(buf []byte) bool {
+	if len(buf) < 0x40 {
+		return false
+	}
+	offset := int64(binary.LittleEndian.Uint32(buf[0x3c:]))
+	return offset+4 <= int64(len(buf)) && bytes.Equal(buf[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// Return the first signature matching the buffer, or nil
+func identifyMagic(buf []byte) *magicEntry {
+	for i := range magicTable {
+		if magicTable[i].match(buf) {
+			return &magicTable[i]
+		}
+	}
+	return nil
+}
+
+// Load signatures from a user file and place them ahead of the built in ones.
+// Each line holds whitespace separated fields:
+//
+//	offset  hex-bytes  mask  mime-type  description...
+//
+// The offset may be prefixed with ~ to skip leading whitespace, the mask is
+// hex as well or - for none. Blank lines and lines starting with # are ignored.
+func loadMagicFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entries []magicEntry
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return fmt.Errorf("%s:%d: expected offset, bytes, mask, mime type and description", path, lineNumber)
+		}
+
+		var entry magicEntry
+		offset := fields[0]
+		if strings.HasPrefix(offset, "~") {
+			entry.SkipSpace = true
+			offset = offset[1:]
+		}
+		value, err := strconv.ParseInt(offset, 0, 32)
+		if err != nil || value < 0 {
+			return fmt.Errorf("%s:%d: invalid offset %q", path, lineNumber, fields[0])
+		}
+		entry.Offset = int(value)
+
+		if entry.Bytes, err = hex.DecodeString(fields[1]); err != nil || len(entry.Bytes) == 0 {
+			return fmt.Errorf("%s:%d: invalid hex bytes %q", path, lineNumber, fields[1])
+		}
+		if fields[2] != "-" {
+			if entry.Mask, err = hex.DecodeString(fields[2]); err != nil || len(entry.Mask) != len(entry.Bytes) {
+				return fmt.Errorf("%s:%d: mask %q must be hex of the same length as the bytes", path, lineNumber, fields[2])
+			}
+		}
+		entry.MimeType = fields[3]
+		entry.Description = strings.Join(fields[4:], " ")
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	magicTable = append(entries, builtinMagicTable...)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lines of unchanged context shown around each change in a diff
+const diffContext = 3
+
+// Apply a replacement template to every line of a file matching the regex.
+// Without write the change is only printed as a unified diff to out, with it
+// the file is rewritten atomically keeping its mode, owner and mtime.
+// Returns the number of lines which changed.
+func replaceInFile(path string, regex *regexp.Regexp, template string, write bool, out io.Writer) (int, error) {
+	// Write through symlinks rather than replacing them with a regular file
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return 0, err
+	}
+
+	oldLines := splitLines(string(data))
+	newLines := make([]string, len(oldLines))
+	changed := 0
+	for i, line := range oldLines {
+		body, eol := trimEOL(line)
+		replaced := regex.ReplaceAllString(body, template) + eol
+		if replaced != line {
+			changed++
+		}
+		newLines[i] = replaced
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if out != nil {
+		printUnifiedDiff(out, path, oldLines, newLines)
+	}
+
+	if write {
+		if err := writeFileAtomic(target, []byte(strings.Join(newLines, "")), info); err != nil {
+			return 0, err
+		}
+	}
+
+	return changed, nil
+}
+
+// Split text into lines which keep their line endings
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Separate a line from its \n or \r\n ending, the same way the scanner does
+func trimEOL(line string) (string, string) {
+	if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], "\r\n"
+	}
+	if strings.HasSuffix(line, "\n") {
+		return line[:len(line)-1], "\n"
+	}
+	return line, ""
+}
+
+// Print a unified diff between two versions of a file. The versions are
+// aligned line by line, a replacement may however expand to several lines.
+func printUnifiedDiff(out io.Writer, path string, oldLines []string, newLines []string) {
+	var changes []int
+	for i := range oldLines {
+		if oldLines[i] != newLines[i] {
+			changes = append(changes, i)
+		}
+	}
+
+	fmt.Fprintln(out, colorize("bo", "--- a/"+path))
+	fmt.Fprintln(out, colorize("bo", "+++ b/"+path))
+
+	// Number of lines a slice of the new version spans
+	span := func(from int, to int) int {
+		count := 0
+		for _, line := range newLines[from:to] {
+			count += len(splitLines(line))
+		}
+		return count
+	}
+
+	for h := 0; h < len(changes); {
+		// Merge changes whose context would overlap into a single hunk
+		last := h
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext {
+			last++
+		}
+		start := changes[h] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[last] + diffContext + 1
+		if end > len(oldLines) {
+			end = len(oldLines)
+		}
+
+		fmt.Fprintln(out, colorize("hd", fmt.Sprintf("@@ -%d,%d +%d,%d @@", start+1, end-start, span(0, start)+1, span(start, end))))
+		for i := start; i < end; {
+			if oldLines[i] == newLines[i] {
+				printDiffLine(out, " ", oldLines[i], "")
+				i++
+				continue
+			}
+
+			// Print a run of changed lines as removals followed by additions
+			run := i
+			for run < end && oldLines[run] != newLines[run] {
+				run++
+			}
+			for _, line := range oldLines[i:run] {
+				printDiffLine(out, "-", line, "rm")
+			}
+			for _, line := range splitLines(strings.Join(newLines[i:run], "")) {
+				printDiffLine(out, "+", line, "ad")
+			}
+			i = run
+		}
+		h = last + 1
+	}
+}
+
+func printDiffLine(out io.Writer, prefix string, line string, color string) {
+	body, eol := trimEOL(line)
+	fmt.Fprintln(out, colorize(color, prefix+replaceNonPrintable(body)))
+	if eol == "" {
+		fmt.Fprintln(out, "\\ No newline at end of file")
+	}
+}
+
+// Replace a file by writing a temporary file next to it and renaming it
+// over the original, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, info os.FileInfo) error {
+	write := func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+	return writeAtomic(path, write, func(tmpPath string) error {
+		// Preserve owner before mode as chown clears the SUID and SGID bits
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Chown(tmpPath, int(stat.Uid), int(stat.Gid)); err != nil {
+				return fmt.Errorf("cannot preserve owner: %v", err)
+			}
+		}
+		if err := os.Chmod(tmpPath, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+		return os.Chtimes(tmpPath, time.Now(), info.ModTime())
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Result is a file which passed every filter, with the lines that matched
+type Result struct {
+	Path    string
+	Meta    Metadata
+	Matches []Match `json:",omitempty"`
+
+	info os.FileInfo
+}
+
+// Match is a single line of a file which matched the content pattern.
+// Column counts bytes from 1 to the first match of a string pattern.
+type Match struct {
+	Line   int
+	Column int    `json:",omitempty"`
+	Text   string `json:",omitempty"`
+}
+
+// Print a result as a single line of JSON
+func printJSON(result Result) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(result)
+}
+
+// Utility function to replace non-printable characters except tabs, for
+// output where lines should read as they do in the file
+func replaceNonPrintableKeepTabs(s string) string {
+	parts := strings.Split(s, "\t")
+	for i := range parts {
+		parts[i] = replaceNonPrintable(parts[i])
+	}
+	return strings.Join(parts, "\t")
+}
+
+// Utility function to replace non-printable characters except tabs with a
+// dot for each of their bytes, so byte columns still point into the text
+func replaceNonPrintableKeepWidths(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i, r := range s {
+		if r == '\t' || (r != utf8.RuneError && strconv.IsPrint(r)) {
+			b.WriteRune(r)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(strings.Repeat(".", size))
+	}
+	return b.String()
+}
+
+// Print every occurrence of pattern in the matched lines of a result as
+// path:line:column:text, with columns counted in bytes from 1 as vim's %c
+// expects. Tabs are kept and what cannot be printed keeps its width so the
+// columns point into the text as printed, the text of quiet binary matches
+// is left out.
+func printVimgrep(result Result, pattern *regexp.Regexp, quiet bool) {
+	for _, match := range result.Matches {
+		text := ""
+		if !quiet {
+			text = replaceNonPrintableKeepWidths(match.Text)
+		}
+		for _, loc := range pattern.FindAllStringIndex(match.Text, -1) {
+			fmt.Printf("%s:%d:%d:%s\n", result.Path, match.Line, loc[0]+1, text)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rule id of the -s pattern
+const patternRuleID = "pattern"
+
+// Rule id of files matched by name or metadata alone
+const fileRuleID = "file"
+
+// contentRule is a named content pattern, from -s or a --rules file
+type contentRule struct {
+	ID    string
+	Regex *regexp.Regexp
+}
+
+var contentRules []contentRule
+
+// Load a rules file of id=regex lines. Blank lines and lines starting
+// with # are skipped.
+func loadRules(path string) ([]contentRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []contentRule
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, pattern, ok := strings.Cut(line, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%s:%d: expected id=regex", path, lineNumber)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%s:%d: duplicate rule '%s'", path, lineNumber, id)
+		}
+		seen[id] = true
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		rules = append(rules, contentRule{ID: id, Regex: regex})
+	}
+	return rules, scanner.Err()
+}
+
+// Pattern matching a line when any of the rules does
+func combineRules(rules []contentRule) string {
+	patterns := make([]string, len(rules))
+	for i, rule := range rules {
+		patterns[i] = "(?:" + rule.Regex.String() + ")"
+	}
+	return strings.Join(patterns, "|")
+}
+
+// Column of the first match of regex in line, counted in bytes from 1, or
+// 0 when it does not match
+func matchColumn(regex *regexp.Regexp, line string) int {
+	loc := regex.FindStringIndex(line)
+	if loc == nil {
+		return 0
+	}
+	return loc[0] + 1
+}
+
+// Utility function to count a byte column of line in characters instead,
+// as the SARIF log declares
+func runeColumn(line string, column int) int {
+	if column < 1 || column > len(line)+1 {
+		return column
+	}
+	return utf8.RuneCountInString(line[:column-1]) + 1
+}
+
+// The subset of SARIF 2.1.0 written by --format sarif
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int           `json:"startLine"`
+	StartColumn int           `json:"startColumn,omitempty"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+// URI of a result, relative paths stay relative so code scanning resolves
+// them against the repository
+func sarifURI(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if filepath.IsAbs(path) {
+		return (&url.URL{Scheme: "file", Path: path}).String()
+	}
+	return (&url.URL{Path: path}).String()
+}
+
+// Build the SARIF log of the results. Each matched line is reported once
+// for every rule matching it, files found without a content pattern are
+// reported under the file rule.
+func buildSarif(results []Result, rules []contentRule) sarifLog {
+	driver := sarifDriver{Name: "ffs", InformationURI: "https://github.com/hollerith/ffs"}
+	for _, rule := range rules {
+		driver.Rules = append(driver.Rules, sarifRule{ID: rule.ID, ShortDescription: sarifMessage{Text: "Matches " + rule.Regex.String()}})
+	}
+	fileRule := -1
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, ColumnKind: "unicodeCodePoints", Results: []sarifResult{}}
+	for _, result := range results {
+		location := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: sarifURI(result.Path)}}
+
+		if len(result.Matches) == 0 || len(rules) == 0 {
+			if fileRule < 0 {
+				fileRule = len(run.Tool.Driver.Rules)
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: fileRuleID, ShortDescription: sarifMessage{Text: "File matches the search"}})
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    fileRuleID,
+				RuleIndex: fileRule,
+				Level:     "warning",
+				Message:   sarifMessage{Text: "File matches the search"},
+				Locations: []sarifLocation{{PhysicalLocation: location}},
+			})
+			continue
+		}
+
+		// The text of binary files is not worth showing
+		snippets := !(binaryMatches && result.Meta.Kind == kindBinary)
+		for _, match := range result.Matches {
+			for i, rule := range rules {
+				// A lone rule matched every line, so its column is already known
+				column := match.Column
+				if len(rules) > 1 {
+					if column = matchColumn(rule.Regex, match.Text); column == 0 {
+						continue
+					}
+				}
+				region := &sarifRegion{StartLine: match.Line, StartColumn: runeColumn(match.Text, column)}
+				if snippets {
+					region.Snippet = &sarifMessage{Text: match.Text}
+				}
+				location.Region = region
+				run.Results = append(run.Results, sarifResult{
+					RuleID:    rule.ID,
+					RuleIndex: i,
+					Level:     "warning",
+					Message:   sarifMessage{Text: fmt.Sprintf("Line %d matches rule %s", match.Line, rule.ID)},
+					Locations: []sarifLocation{{PhysicalLocation: location}},
+				})
+			}
+		}
+	}
+
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
+
+// Print the SARIF log of the results
+func printSarif(results []Result, rules []contentRule) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(buildSarif(results, rules))
+}
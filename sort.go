@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Keys results can be sorted by. Sizes, times and match counts sort largest
+// first like ls -S and ls -t, names and paths alphabetically.
+var sortKeys = map[string]bool{"name": true, "path": true, "size": true, "mtime": true, "matches": true}
+
+// Check whether a sorts before b, ties are broken by path
+func lessResult(a Result, b Result, key string) bool {
+	switch key {
+	case "name":
+		if nameA, nameB := filepath.Base(a.Path), filepath.Base(b.Path); nameA != nameB {
+			return nameA < nameB
+		}
+	case "size":
+		if a.Meta.Size != b.Meta.Size {
+			return a.Meta.Size > b.Meta.Size
+		}
+	case "mtime":
+		if timeA, timeB := resultModTime(a), resultModTime(b); !timeA.Equal(timeB) {
+			return timeA.After(timeB)
+		}
+	case "matches":
+		if len(a.Matches) != len(b.Matches) {
+			return len(a.Matches) > len(b.Matches)
+		}
+	}
+	return a.Path < b.Path
+}
+
+// Modification time of a result, streams have none
+func resultModTime(result Result) time.Time {
+	if result.info == nil {
+		return time.Time{}
+	}
+	return result.info.ModTime()
+}
+
+// Sort results by key, or leave them in walk order without one
+func sortResults(results []Result, key string, reverse bool) {
+	if key == "" {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if reverse {
+			return lessResult(results[j], results[i], key)
+		}
+		return lessResult(results[i], results[j], key)
+	})
+}
+
+// Keep the first top results in sorted order. Called as results are
+// buffered so no more than twice top are ever held.
+func trimResults(results []Result, key string, reverse bool, top int) []Result {
+	if top <= 0 || len(results) <= top {
+		return results
+	}
+	sortResults(results, key, reverse)
+	return results[:top]
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Inode change time, which moves on chmod, chown and xattr changes too
+func changeTime(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ctim.Nano()
+	}
+	return 0
+}
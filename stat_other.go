@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// Inode change time is not portable, the cache relies on size and mtime
+func changeTime(info os.FileInfo) int64 {
+	return 0
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rows of each breakdown shown in the colored report, JSON has them all
+const statsTextRows = 10
+
+// Widest bar of the size histogram
+const statsBarWidth = 40
+
+// Upper bounds of the size histogram buckets, the last one is open ended
+var statsBuckets = []int64{1, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20, 1 << 30}
+
+// StatsGroup totals the results sharing an extension, MIME type, owner or directory
+type StatsGroup struct {
+	Name    string
+	Files   int
+	Bytes   int64
+	Matches int
+}
+
+// SizeBucket counts the results whose size is at least Min and below Max,
+// Max is 0 for the last bucket
+type SizeBucket struct {
+	Min   int64
+	Max   int64
+	Files int
+	Bytes int64
+}
+
+// Stats is the --stats report
+type Stats struct {
+	Files          int
+	Bytes          int64
+	Matches        int
+	ScannedFiles   int
+	ScannedBytes   int64
+	Elapsed        float64
+	BytesPerSecond float64
+	Extensions     []StatsGroup
+	MimeTypes      []StatsGroup
+	Owners         []StatsGroup
+	Directories    []StatsGroup
+	Sizes          []SizeBucket
+}
+
+// statsCollector accumulates the report as files are searched and reported
+type statsCollector struct {
+	start time.Time
+	stats Stats
+
+	extensions  map[string]*StatsGroup
+	mimeTypes   map[string]*StatsGroup
+	owners      map[string]*StatsGroup
+	directories map[string]*StatsGroup
+}
+
+func newStatsCollector() *statsCollector {
+	collector := &statsCollector{
+		start:       time.Now(),
+		extensions:  make(map[string]*StatsGroup),
+		mimeTypes:   make(map[string]*StatsGroup),
+		owners:      make(map[string]*StatsGroup),
+		directories: make(map[string]*StatsGroup),
+	}
+	for i, max := range statsBuckets {
+		bucket := SizeBucket{Max: max}
+		if i > 0 {
+			bucket.Min = statsBuckets[i-1]
+		}
+		collector.stats.Sizes = append(collector.stats.Sizes, bucket)
+	}
+	collector.stats.Sizes = append(collector.stats.Sizes, SizeBucket{Min: statsBuckets[len(statsBuckets)-1]})
+	return collector
+}
+
+// Count a file which was opened to be searched
+func (collector *statsCollector) scanned(size int64) {
+	collector.stats.ScannedFiles++
+	collector.stats.ScannedBytes += size
+}
+
+// Count a reported result in every breakdown
+func (collector *statsCollector) add(result Result) {
+	// As in the summary, a symlink counts as a file but not its size
+	size := result.Meta.Size
+	if result.Meta.Link != "" {
+		size = 0
+	}
+	matches := len(result.Matches)
+
+	collector.stats.Files++
+	collector.stats.Bytes += size
+	collector.stats.Matches += matches
+
+	extension := strings.ToLower(filepath.Ext(result.Path))
+	if extension == "" {
+		extension = "(none)"
+	}
+	mediaType, _, err := mime.ParseMediaType(result.Meta.MimeType)
+	if err != nil {
+		mediaType = result.Meta.MimeType
+	}
+	owner := result.Meta.Owner
+	if owner == "" {
+		owner = "(unknown)"
+	}
+
+	for _, group := range []struct {
+		groups map[string]*StatsGroup
+		name   string
+	}{
+		{collector.extensions, extension},
+		{collector.mimeTypes, mediaType},
+		{collector.owners, owner},
+		{collector.directories, filepath.Dir(result.Path)},
+	} {
+		entry, ok := group.groups[group.name]
+		if !ok {
+			entry = &StatsGroup{Name: group.name}
+			group.groups[group.name] = entry
+		}
+		entry.Files++
+		entry.Bytes += size
+		entry.Matches += matches
+	}
+
+	for i := range collector.stats.Sizes {
+		bucket := &collector.stats.Sizes[i]
+		if size >= bucket.Min && (bucket.Max == 0 || size < bucket.Max) {
+			bucket.Files++
+			bucket.Bytes += size
+			break
+		}
+	}
+}
+
+// Finish the report, with the breakdowns largest first
+func (collector *statsCollector) report() Stats {
+	stats := collector.stats
+	stats.Elapsed = time.Since(collector.start).Seconds()
+	if stats.Elapsed > 0 {
+		stats.BytesPerSecond = float64(stats.ScannedBytes) / stats.Elapsed
+	}
+	stats.Extensions = sortedGroups(collector.extensions)
+	stats.MimeTypes = sortedGroups(collector.mimeTypes)
+	stats.Owners = sortedGroups(collector.owners)
+	stats.Directories = sortedGroups(collector.directories)
+	return stats
+}
+
+func sortedGroups(groups map[string]*StatsGroup) []StatsGroup {
+	sorted := make([]StatsGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Bytes != sorted[j].Bytes {
+			return sorted[i].Bytes > sorted[j].Bytes
+		}
+		if sorted[i].Files != sorted[j].Files {
+			return sorted[i].Files > sorted[j].Files
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// Print the report in the selected output format
+func printStats(stats Stats, format string) {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(struct{ Stats Stats }{stats})
+		return
+	}
+
+	printStatsGroups("by extension", stats.Extensions)
+	printStatsGroups("by type", stats.MimeTypes)
+	printStatsGroups("by owner", stats.Owners)
+	printStatsGroups("by directory", stats.Directories)
+
+	fmt.Println("\n" + colorize("hd", "- by size:"))
+	most := 0
+	for _, bucket := range stats.Sizes {
+		if bucket.Files > most {
+			most = bucket.Files
+		}
+	}
+	for _, bucket := range stats.Sizes {
+		label := "empty"
+		switch {
+		case bucket.Max == 0:
+			label = ">= " + humanizeBytes(bucket.Min)
+		case bucket.Min > 0:
+			label = "< " + humanizeBytes(bucket.Max)
+		}
+		bar := 0
+		if most > 0 {
+			bar = (bucket.Files*statsBarWidth + most - 1) / most
+		}
+		fmt.Printf("  %10s %8d %s\n", label, bucket.Files, colorize("hi", strings.Repeat("█", bar)))
+	}
+
+	fmt.Printf("\n%s %d files, %s in %.2fs (%s)\n\n", colorize("hd", "- scanned:"), stats.ScannedFiles, humanizeBytes(stats.ScannedBytes), stats.Elapsed, colorize("hi", humanizeBytes(int64(stats.BytesPerSecond))+"/s"))
+}
+
+func printStatsGroups(title string, groups []StatsGroup) {
+	fmt.Printf("\n%s\n", colorize("hd", "- "+title+":"))
+	fmt.Println(colorize("dm", fmt.Sprintf("  %8s %10s %8s  %s", "files", "bytes", "matches", "name")))
+	for i, group := range groups {
+		if i == statsTextRows {
+			fmt.Println(colorize("dm", fmt.Sprintf("  ... %d more", len(groups)-statsTextRows)))
+			break
+		}
+		fmt.Printf("  %8d %10s %8d  %s\n", group.Files, humanizeBytes(group.Bytes), group.Matches, group.Name)
+	}
+}
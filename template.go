@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Template each result is printed with when --template or --template-file is given
+var outputTemplate *template.Template
+
+// Colors known to the color template function besides the palette keys,
+// anything else is taken as a 256 color palette number
+var templateColors = map[string]string{
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"gray":    "90",
+	"bold":    "1",
+}
+
+// Functions available to output templates
+var templateFuncs = template.FuncMap{
+	"humanize": humanizeBytes,
+	"relpath": func(path string, base ...string) (string, error) {
+		from := "."
+		if len(base) > 0 {
+			from = base[0]
+		}
+		absFrom, err := filepath.Abs(from)
+		if err != nil {
+			return "", err
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Rel(absFrom, absPath)
+	},
+	"hex": func(s string) string {
+		return hex.EncodeToString([]byte(s))
+	},
+	"color": func(name string, text interface{}) string {
+		code, ok := palette[name]
+		if !ok {
+			code, ok = templateColors[name]
+		}
+		if !ok {
+			code = "38;5;" + name
+		}
+		return paint(code, fmt.Sprint(text))
+	},
+}
+
+// Parse the output template given on the command line, where \t, \n and
+// \\ stand for a tab, a newline and a backslash, or read from a file
+func parseOutputTemplate(text string, file string) (*template.Template, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return template.New(filepath.Base(file)).Funcs(templateFuncs).Parse(string(data))
+	}
+	text = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n").Replace(text)
+	return template.New("template").Funcs(templateFuncs).Parse(text)
+}
+
+// Print data with the output template, ending it with a newline unless it
+// already ends with one. Nothing is printed when the template renders empty.
+func printTemplate(data interface{}) {
+	var buf bytes.Buffer
+	if err := outputTemplate.Execute(&buf, data); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	os.Stdout.Write(buf.Bytes())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is the terminal mode to restore after raw mode
+type terminalState struct {
+	termios syscall.Termios
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Put a terminal into raw mode, where every key is read as it is pressed
+// without echo, returning the state to restore afterwards
+func makeRaw(fd int) (*terminalState, error) {
+	var state terminalState
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&state.termios)); err != nil {
+		return nil, err
+	}
+
+	raw := state.termios
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Put a terminal back into the mode it had before makeRaw
+func restoreTerminal(fd int, state *terminalState) error {
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&state.termios))
+}
+
+// Number of columns and rows of a terminal
+func terminalSize(fd int) (int, int, error) {
+	var size struct {
+		rows, cols, x, y uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
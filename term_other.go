@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errTerminalUnsupported = errors.New("--interactive is only supported on Linux")
+
+type terminalState struct{}
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, errTerminalUnsupported
+}
+
+func restoreTerminal(fd int, state *terminalState) error {
+	return errTerminalUnsupported
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errTerminalUnsupported
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeNode is a directory or file of the result hierarchy. Directories
+// hold the number of matching files and their total size below them.
+type treeNode struct {
+	name     string
+	children map[string]*treeNode
+	result   *Result
+	files    int
+	size     int64
+}
+
+func newTreeNode(name string) *treeNode {
+	return &treeNode{name: name, children: make(map[string]*treeNode)}
+}
+
+// Build the hierarchy of results below root. Results outside root, from
+// other operands or a file list, hang off their own top level directories.
+func buildTree(root string, results []Result) *treeNode {
+	top := newTreeNode(root)
+	for i := range results {
+		result := &results[i]
+		path := filepath.Clean(result.Path)
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			path = rel
+		}
+
+		parts := strings.Split(path, string(os.PathSeparator))
+		if parts[0] == "" {
+			// An absolute path outside root
+			parts[0] = string(os.PathSeparator)
+		}
+
+		// Symlinks do not take up the space of their targets
+		var size int64
+		if result.Meta.Link == "" {
+			size = result.Meta.Size
+		}
+
+		node := top
+		for _, part := range parts[:len(parts)-1] {
+			node.files++
+			node.size += size
+			child, ok := node.children[part]
+			if !ok {
+				child = newTreeNode(part)
+				node.children[part] = child
+			}
+			node = child
+		}
+		node.files++
+		node.size += size
+
+		leaf := newTreeNode(parts[len(parts)-1])
+		leaf.result = result
+		node.children[leaf.name] = leaf
+	}
+	return top
+}
+
+// Print the result hierarchy with box-drawing connectors, each directory
+// once with the number and size of the matching files below it
+func printTree(root string, results []Result, verbose bool) {
+	top := buildTree(root, results)
+	fmt.Printf("%s%s\n", colorize("di", top.name), treeSummary(top))
+	printTreeChildren(top, "", verbose)
+}
+
+func printTreeChildren(node *treeNode, prefix string, verbose bool) {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		child := node.children[name]
+		connector, indent := "├── ", "│   "
+		if i == len(names)-1 {
+			connector, indent = "└── ", "    "
+		}
+
+		if child.result == nil {
+			name := child.name
+			if !strings.HasSuffix(name, string(os.PathSeparator)) {
+				name += string(os.PathSeparator)
+			}
+			fmt.Printf("%s%s%s%s\n", prefix, connector, colorize("di", name), treeSummary(child))
+			printTreeChildren(child, prefix+indent, verbose)
+			continue
+		}
+
+		result := child.result
+		label := colorize("fi", child.name)
+		if result.Meta.Link != "" {
+			label = colorize("ln", child.name) + " --> " + result.Meta.Link
+		}
+		details := ""
+		if verbose {
+			details = " " + humanizeBytes(result.Meta.Size)
+		}
+		if len(result.Matches) == 1 {
+			details += " (1 match)"
+		} else if len(result.Matches) > 1 {
+			details += fmt.Sprintf(" (%d matches)", len(result.Matches))
+		}
+		fmt.Printf("%s%s%s%s\n", prefix, connector, label, colorize("dm", details))
+
+		// Matched lines hang below their file in verbose mode
+		if verbose && !(binaryMatches && result.Meta.Kind == kindBinary) {
+			for _, match := range result.Matches {
+				fmt.Printf("%s%s  %s:%s\n", prefix, indent, colorize("nu", strconv.Itoa(match.Line)), colorize("mt", replaceNonPrintable(match.Text)))
+			}
+		}
+	}
+}
+
+func treeSummary(node *treeNode) string {
+	noun := "files"
+	if node.files == 1 {
+		noun = "file"
+	}
+	return " " + colorize("dm", fmt.Sprintf("(%d %s, %s)", node.files, noun, humanizeBytes(node.size)))
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// Most of a file read into the preview pane
+const previewMaxBytes = 1024 * 1024
+
+// browser is the state of the --interactive results browser
+type browser struct {
+	tty     *os.File
+	state   *terminalState
+	out     *bufio.Writer
+	results []Result
+
+	// Results shown after filtering, as indexes into results
+	visible  []int
+	selected int
+	offset   int
+	match    int
+
+	highlight *regexp.Regexp
+	filter    *regexp.Regexp
+	input     string
+	editing   bool
+	status    string
+
+	cols, rows int
+
+	previewPath  string
+	previewLines []string
+	previewError string
+}
+
+// Browse the results on the terminal until the user quits. Keys are read
+// from /dev/tty so results can still come from piped input.
+func runInteractive(results []Result, highlight *regexp.Regexp) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	b := &browser{tty: tty, out: bufio.NewWriter(tty), results: results, highlight: highlight}
+	b.applyFilter("")
+	if err := b.enter(); err != nil {
+		return err
+	}
+	defer b.leave()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	// Keys are read one request at a time, so nothing is read from the
+	// terminal while an editor has it
+	requests := make(chan bool)
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 256)
+		for range requests {
+			n, err := tty.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	requests <- true
+	for {
+		b.draw()
+		select {
+		case <-winch:
+			b.resize()
+		case data, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range decodeKeys(data) {
+				if !b.handle(key) {
+					return nil
+				}
+			}
+			requests <- true
+		}
+	}
+}
+
+// Switch the terminal to raw mode and the alternate screen
+func (b *browser) enter() error {
+	state, err := makeRaw(int(b.tty.Fd()))
+	if err != nil {
+		return err
+	}
+	b.state = state
+	b.out.WriteString("\x1b[?1049h\x1b[?25l")
+	b.resize()
+	return nil
+}
+
+// Restore the terminal as it was before enter
+func (b *browser) leave() {
+	b.out.WriteString("\x1b[?25h\x1b[?1049l")
+	b.out.Flush()
+	restoreTerminal(int(b.tty.Fd()), b.state)
+}
+
+func (b *browser) resize() {
+	b.cols, b.rows = 80, 24
+	if cols, rows, err := terminalSize(int(b.tty.Fd())); err == nil && cols > 0 && rows > 0 {
+		b.cols, b.rows = cols, rows
+	}
+}
+
+// Decode terminal input into key names, or the typed characters
+func decodeKeys(data []byte) []string {
+	sequences := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1bOH": "home", "\x1bOF": "end",
+		"\x1b[1~": "home", "\x1b[4~": "end",
+	}
+
+	var keys []string
+	for len(data) > 0 {
+		matched := false
+		for seq, name := range sequences {
+			if strings.HasPrefix(string(data), seq) {
+				keys = append(keys, name)
+				data = data[len(seq):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		switch data[0] {
+		case 0x1b:
+			keys = append(keys, "esc")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		case 0x15:
+			keys = append(keys, "ctrl-u")
+		default:
+			r, size := utf8.DecodeRune(data)
+			if r != utf8.RuneError && r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
+
+// Act on a key, returning false to quit
+func (b *browser) handle(key string) bool {
+	b.status = ""
+
+	if b.editing {
+		switch key {
+		case "enter":
+			b.editing = false
+		case "esc":
+			b.editing = false
+			b.applyFilter("")
+		case "ctrl-c":
+			return false
+		case "backspace":
+			if b.input != "" {
+				_, size := utf8.DecodeLastRuneInString(b.input)
+				b.applyFilter(b.input[:len(b.input)-size])
+			}
+		case "ctrl-u":
+			b.applyFilter("")
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				b.applyFilter(b.input + key)
+			}
+		}
+		return true
+	}
+
+	page := b.listHeight()
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case "esc":
+		b.applyFilter("")
+	case "up", "k":
+		b.moveTo(b.selected - 1)
+	case "down", "j":
+		b.moveTo(b.selected + 1)
+	case "pgup":
+		b.moveTo(b.selected - page)
+	case "pgdn":
+		b.moveTo(b.selected + page)
+	case "home", "g":
+		b.moveTo(0)
+	case "end", "G":
+		b.moveTo(len(b.visible) - 1)
+	case "right", "l", "n":
+		if result := b.current(); result != nil && b.match+1 < len(result.Matches) {
+			b.match++
+		}
+	case "left", "h", "N":
+		if b.match > 0 {
+			b.match--
+		}
+	case "/":
+		b.editing = true
+	case "enter", "e":
+		b.openEditor()
+	}
+	return true
+}
+
+// Filter the results to those whose path or matched lines match pattern,
+// keeping the previous filter while the pattern does not compile
+func (b *browser) applyFilter(pattern string) {
+	b.input = pattern
+	var filter *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if filter, err = regexp.Compile(pattern); err != nil {
+			b.status = "invalid pattern: " + err.Error()
+			return
+		}
+	}
+	b.filter = filter
+
+	b.visible = b.visible[:0]
+	for i, result := range b.results {
+		if b.filter == nil || b.filter.MatchString(result.Path) || matchesAny(b.filter, result.Matches) {
+			b.visible = append(b.visible, i)
+		}
+	}
+	// The first result may now be another one, with fewer matches
+	b.offset = 0
+	b.match = 0
+	b.moveTo(0)
+}
+
+func matchesAny(regex *regexp.Regexp, matches []Match) bool {
+	for _, match := range matches {
+		if regex.MatchString(match.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *browser) moveTo(selected int) {
+	if selected >= len(b.visible) {
+		selected = len(b.visible) - 1
+	}
+	if selected < 0 {
+		selected = 0
+	}
+	if selected != b.selected {
+		b.match = 0
+	}
+	b.selected = selected
+
+	// Keep the selection in view
+	if b.selected < b.offset {
+		b.offset = b.selected
+	}
+	if height := b.listHeight(); b.selected >= b.offset+height {
+		b.offset = b.selected - height + 1
+	}
+}
+
+func (b *browser) current() *Result {
+	if b.selected >= len(b.visible) {
+		return nil
+	}
+	return &b.results[b.visible[b.selected]]
+}
+
+// Rows of the result list, the preview takes the rest below the header,
+// separator and status line
+func (b *browser) listHeight() int {
+	height := (b.rows - 3) / 2
+	if height < 1 {
+		height = 1
+	}
+	return height
+}
+
+// Line of the selected result to show and open the editor at
+func (b *browser) focusLine() int {
+	result := b.current()
+	if result == nil || b.match >= len(result.Matches) {
+		return 1
+	}
+	return result.Matches[b.match].Line
+}
+
+// Redraw the whole screen
+func (b *browser) draw() {
+	listHeight := b.listHeight()
+	row := 1
+
+	b.line(row, fmt.Sprintf("\x1b[7m ffs  %d/%d results%s", len(b.visible), len(b.results), b.filterLabel()), "\x1b[7m")
+	row++
+
+	for i := 0; i < listHeight; i++ {
+		index := b.offset + i
+		if index >= len(b.visible) {
+			b.line(row, "", "")
+		} else {
+			b.line(row, b.listRow(b.results[b.visible[index]], index == b.selected), "")
+		}
+		row++
+	}
+
+	result := b.current()
+	title := "──"
+	if result != nil {
+		title = fmt.Sprintf("── %s ", result.Path)
+		if b.match < len(result.Matches) {
+			title += fmt.Sprintf("(line %d, match %d/%d) ", b.focusLine(), b.match+1, len(result.Matches))
+		}
+	}
+	b.line(row, colorize("dm", title+strings.Repeat("─", b.cols)), "")
+	row++
+
+	previewHeight := b.rows - row
+	for i, text := range b.previewRows(result, previewHeight) {
+		b.line(row+i, text, "")
+	}
+
+	switch {
+	case b.editing:
+		b.line(b.rows, "/"+b.input+"\x1b[7m \x1b[0m", "")
+	case b.status != "":
+		b.line(b.rows, colorize("er", b.status), "")
+	default:
+		b.line(b.rows, colorize("dm", "↑↓ move  ←→ matches  / filter  enter edit  q quit"), "")
+	}
+	b.out.Flush()
+}
+
+func (b *browser) filterLabel() string {
+	if b.input == "" {
+		return ""
+	}
+	return fmt.Sprintf("  filter /%s/", b.input)
+}
+
+// Print text at the start of a screen row, clearing the rest of it. style
+// is reapplied to fill the row, as a reverse video bar does.
+func (b *browser) line(row int, text string, style string) {
+	fmt.Fprintf(b.out, "\x1b[%d;1H%s\x1b[K", row, truncateDisplay(text, b.cols))
+	if style != "" {
+		fmt.Fprintf(b.out, "%s%s", style, strings.Repeat(" ", maxInt(0, b.cols-displayWidth(text))))
+	}
+	b.out.WriteString("\x1b[0m")
+}
+
+// A result as a row of the verbose listing columns, ending with its path
+// in place of the name and the number of matches
+func (b *browser) listRow(result Result, selected bool) string {
+	var columns []column
+	for _, c := range listColumns {
+		if c.name != "name" && c.name != "path" {
+			columns = append(columns, c)
+		}
+	}
+	name, _ := newColumn("name")
+	columns = append(columns, name)
+
+	// The colors of the columns would end the reverse video of the selection
+	if selected {
+		defer func(color bool) { useColor = color }(useColor)
+		useColor = false
+	}
+	row := renderRow(columns, result.Meta, result.info, "", result.Path)
+	if len(result.Matches) > 0 {
+		row += fmt.Sprintf(" (%d)", len(result.Matches))
+	}
+	if selected {
+		return "\x1b[7m" + row + strings.Repeat(" ", maxInt(0, b.cols-displayWidth(row)))
+	}
+	return row
+}
+
+// Lines of the preview pane, centred on the focused match
+func (b *browser) previewRows(result *Result, height int) []string {
+	rows := make([]string, height)
+	if result == nil {
+		return rows
+	}
+	if result.Meta.Kind == kindBinary || result.info == nil {
+		rows[0] = colorize("dm", result.Meta.MimeType+" "+result.Meta.Description)
+		return rows
+	}
+	b.loadPreview(*result)
+	if b.previewError != "" {
+		rows[0] = colorize("er", b.previewError)
+		return rows
+	}
+
+	focus := b.focusLine()
+	start := focus - height/3
+	if start < 1 {
+		start = 1
+	}
+	var highlights []*regexp.Regexp
+	for _, regex := range []*regexp.Regexp{b.highlight, b.filter} {
+		if regex != nil {
+			highlights = append(highlights, regex)
+		}
+	}
+
+	for i := range rows {
+		number := start + i
+		if number > len(b.previewLines) {
+			break
+		}
+		text := truncateDisplay(expandTabs(replaceNonPrintable(b.previewLines[number-1])), b.cols-9)
+		text = highlightMatches(text, highlights)
+		gutter := "dm"
+		if number == focus {
+			gutter = "nu"
+		}
+		rows[i] = fmt.Sprintf("%s %s", colorize(gutter, fmt.Sprintf("%6d │", number)), text)
+	}
+	return rows
+}
+
+// Read the lines of the selected file, decoded like the content scan
+func (b *browser) loadPreview(result Result) {
+	if b.previewPath == result.Path {
+		return
+	}
+	b.previewPath = result.Path
+	b.previewLines = nil
+	b.previewError = ""
+
+	file, err := os.Open(result.Path)
+	if err != nil {
+		b.previewError = err.Error()
+		return
+	}
+	defer file.Close()
+
+	enc := textEncoding
+	if enc == "auto" {
+		enc = result.Meta.Encoding
+	}
+	scanner := bufio.NewScanner(newDecoder(io.LimitReader(file, previewMaxBytes), enc))
+	scanner.Buffer(make([]byte, 64*1024), previewMaxBytes)
+	for scanner.Scan() {
+		b.previewLines = append(b.previewLines, scanner.Text())
+	}
+}
+
+// Open the selected file in $VISUAL or $EDITOR at the focused line
+func (b *browser) openEditor() {
+	result := b.current()
+	if result == nil || result.info == nil {
+		return
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), fmt.Sprintf("+%d", b.focusLine()), result.Path)
+
+	b.leave()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = b.tty, b.tty, b.tty
+	err := cmd.Run()
+	if enterErr := b.enter(); enterErr != nil {
+		b.status = enterErr.Error()
+		return
+	}
+	if err != nil {
+		b.status = fmt.Sprintf("%s: %v", args[0], err)
+	}
+
+	// The file may have changed
+	b.previewPath = ""
+}
+
+// Mark what any of the regexes match in text, overlapping matches merge
+func highlightMatches(text string, regexes []*regexp.Regexp) string {
+	marked := make([]bool, len(text))
+	for _, regex := range regexes {
+		for _, loc := range regex.FindAllStringIndex(text, -1) {
+			for i := loc[0]; i < loc[1]; i++ {
+				marked[i] = true
+			}
+		}
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(text); {
+		end := start + 1
+		for end < len(text) && marked[end] == marked[start] {
+			end++
+		}
+		if marked[start] {
+			out.WriteString(colorize("hl", text[start:end]))
+		} else {
+			out.WriteString(text[start:end])
+		}
+		start = end
+	}
+	return out.String()
+}
+
+// Utility function to expand tabs to the next multiple of four columns
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	var out strings.Builder
+	column := 0
+	for _, r := range s {
+		if r == '\t' {
+			spaces := 4 - column%4
+			out.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+			continue
+		}
+		out.WriteRune(r)
+		column++
+	}
+	return out.String()
+}
+
+// Number of columns text takes up, ignoring escape sequences
+func displayWidth(text string) int {
+	width := 0
+	inEscape := false
+	for _, r := range text {
+		switch {
+		case inEscape:
+			inEscape = !(r >= '@' && r <= '~' && r != '[')
+		case r == 0x1b:
+			inEscape = true
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// Cut text down to width columns, keeping its escape sequences
+func truncateDisplay(text string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	var out strings.Builder
+	column := 0
+	inEscape := false
+	for _, r := range text {
+		switch {
+		case inEscape:
+			inEscape = !(r >= '@' && r <= '~' && r != '[')
+		case r == 0x1b:
+			inEscape = true
+		default:
+			if column == width {
+				continue
+			}
+			column++
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,25 +1,37 @@
 package main
 
 import (
+	"bufio"
     "net/http"
 	"fmt"
 	"strings"
 	"strconv"
 	"os"
 	"os/user"
+	"io"
 	"io/ioutil"
 	"syscall"
 	"path/filepath"
+	"sync"
+	"unicode/utf8"
 )
 
+// Bytes read from the start of each file to identify its type, enough for
+// every signature in the magic table
+const sniffSize = 64 * 1024
+
+// Utility function to mask control characters and invalid UTF-8 sequences
 func replaceNonPrintable(s string) string {
-	b := []byte(s)
-	for i, c := range b {
-		if !strconv.IsPrint(rune(c)) {
-			b[i] = '.'
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r == utf8.RuneError || !strconv.IsPrint(r) {
+			b.WriteByte('.')
+		} else {
+			b.WriteRune(r)
 		}
 	}
-	return string(b)
+	return b.String()
 }
 
 // Utility function to convert bytes to a human-readable format
@@ -36,6 +48,41 @@ func humanizeBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// Utility function to replace a file by writing a temporary file next to
+// it and renaming it over path, so readers never see a partially written
+// file. prepare, when given, may change the temporary file before then.
+func writeAtomic(path string, write func(w io.Writer) error, prepare func(tmpPath string) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".ffs-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	writer := bufio.NewWriter(tmp)
+	if err := write(writer); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err := prepare(tmpPath); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
+
 func Decode(data []byte) string {
 	var exifData strings.Builder
 	var currentString string
@@ -65,12 +112,58 @@ func Decode(data []byte) string {
 	return exifData.String()
 }
 
-func extractFileData(file *os.File) (Metadata, bool, error) {
+// Owner and group names resolved so far, os/user parses /etc/passwd and
+// /etc/group again on every lookup
+var (
+    namesMutex sync.Mutex
+    ownerNames = make(map[uint32]string)
+    groupNames = make(map[uint32]string)
+)
+
+// Utility function to format a uid with its user name, memoized
+func lookupOwner(uid uint32) string {
+    namesMutex.Lock()
+    defer namesMutex.Unlock()
+
+    if name, ok := ownerNames[uid]; ok {
+        return name
+    }
+    name := fmt.Sprintf("%d", uid)
+    if u, err := user.LookupId(name); err == nil {
+        name = fmt.Sprintf("%d - %s", uid, u.Username)
+    }
+    ownerNames[uid] = name
+    return name
+}
+
+// Utility function to format a gid with its group name, memoized
+func lookupGroup(gid uint32) string {
+    namesMutex.Lock()
+    defer namesMutex.Unlock()
+
+    if name, ok := groupNames[gid]; ok {
+        return name
+    }
+    name := fmt.Sprintf("%d", gid)
+    if g, err := user.LookupGroupId(name); err == nil {
+        name = fmt.Sprintf("%d - %s", gid, g.Name)
+    }
+    groupNames[gid] = name
+    return name
+}
+
+// Extract the metadata of an open file. fileInfo is what the walker found
+// at its path, the file is only stat-ed again when that is missing or
+// describes a symlink rather than its target.
+func extractFileData(file *os.File, fileInfo os.FileInfo) (Metadata, bool, error) {
     var metadata Metadata
     isBinary := false
 
     // Get file size, mode, owner, and group
-    fileInfo, err := file.Stat()
+    var err error
+    if fileInfo == nil || fileInfo.Mode()&os.ModeSymlink != 0 {
+        fileInfo, err = file.Stat()
+    }
     if err == nil {
         metadata.Size = fileInfo.Size()
         metadata.Mode = fileInfo.Mode().String()
@@ -81,45 +174,43 @@ func extractFileData(file *os.File) (Metadata, bool, error) {
         gid := fileInfo.Sys().(*syscall.Stat_t).Gid
 
         // Get owner and group names
-        u, err := user.LookupId(fmt.Sprintf("%d", uid))
-        if err == nil {
-            metadata.Owner = fmt.Sprintf("%d - %s", uid, u.Username)
-        } else {
-            metadata.Owner = fmt.Sprintf("%d", uid)
-        }
-
-        g, err := user.LookupGroupId(fmt.Sprintf("%d", gid))
-        if err == nil {
-            metadata.Group = fmt.Sprintf("%d - %s", gid, g.Name)
-        } else {
-            metadata.Group = fmt.Sprintf("%d", gid)
-        }
+        metadata.Owner = lookupOwner(uid)
+        metadata.Group = lookupGroup(gid)
 
         // Get file mod time
         modTime := fileInfo.ModTime().Format("2006-01-02 15:04:05")
         metadata.ModTime = modTime
     }
 
-    // Determine number of bytes to read
-    numBytes := fileInfo.Size()
-    if numBytes > 512 {
-        numBytes = 512
-    }
-
     // Reset file pointer to the beginning of the file
     file.Seek(0, 0)
 
-    // Read the file into a buffer
-    buf, err := ioutil.ReadAll(file)
+    // Read the start of the file into a buffer, the content is only read
+    // in full once, by the content scan
+    buf, err := ioutil.ReadAll(io.LimitReader(file, sniffSize))
     if err != nil {
         return metadata, isBinary, err
     }
 
     metadata.MimeType = http.DetectContentType(buf)
 
-    // Check if MIME type belongs to a group of known binary file types
-    if !strings.HasPrefix(metadata.MimeType, "text/") {
-        isBinary = true
+    // Prefer our signature table, it knows far more than web formats
+    if entry := identifyMagic(buf); entry != nil {
+        metadata.MimeType = entry.MimeType
+        metadata.Description = entry.Description
+    }
+    metadata.Encoding = detectEncoding(buf)
+
+    // Classify the content as text, binary or empty
+    metadata.Kind = classifyContent(buf, metadata.MimeType, metadata.Encoding)
+    isBinary = metadata.Kind == kindBinary
+
+    // Read extended attributes, ACLs and file capabilities
+    readXattrs(file.Name(), &metadata)
+
+    // Look inside executables for architecture, linking and hardening details
+    if inspectExecutables {
+        metadata.Exe = inspectExecutable(file, buf, metadata.Size)
     }
 
     // If the file is not an image type return without exifdata
@@ -129,6 +220,7 @@ func extractFileData(file *os.File) (Metadata, bool, error) {
 
     // Decode the EXIF data from the buffer
 	metadata.ExifData = Decode(buf)
+    metadata.Exif = parseExif(buf)
 
     return metadata, isBinary, nil
 }
@@ -142,6 +234,46 @@ func formatColumn(s string, width int) string {
 	}
 }
 
+// Utility function to read a list of paths from a file or stdin for "-".
+// Paths are NUL separated if the list holds any NUL, otherwise one per line.
+func readPathList(name string) ([]string, error) {
+    var data []byte
+    var err error
+    if name == "-" {
+        data, err = ioutil.ReadAll(os.Stdin)
+    } else {
+        data, err = ioutil.ReadFile(name)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    separator := "\n"
+    if strings.Contains(string(data), "\x00") {
+        separator = "\x00"
+    }
+
+    var paths []string
+    for _, path := range strings.Split(string(data), separator) {
+        if separator == "\n" {
+            path = strings.TrimSuffix(path, "\r")
+        }
+        if path != "" {
+            paths = append(paths, path)
+        }
+    }
+    return paths, nil
+}
+
+// Utility function to tell whether stdin is piped or redirected from a file
+func stdinIsPiped() bool {
+    info, err := os.Stdin.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
 // Utility function to convert file glob to regex pattern
 func globToRegex(pattern string) string {
     pattern = strings.Replace(pattern, ".", "\\.", -1)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Kinds of change reported by --watch
+const (
+	watchAdded   = "+" // the file matches now
+	watchRemoved = "-" // the file no longer matches, or is gone
+	watchChanged = "*" // the file still matches, with new matching lines
+)
+
+// WatchEvent is a change in whether a file matches, Matches only holds the
+// lines which were not matching before
+type WatchEvent struct {
+	Event string
+	Result
+}
+
+// Matches of a file which were not among its earlier matches
+func newMatches(before []Match, after []Match) []Match {
+	seen := make(map[Match]bool, len(before))
+	for _, match := range before {
+		seen[match] = true
+	}
+	var fresh []Match
+	for _, match := range after {
+		if !seen[match] {
+			fresh = append(fresh, match)
+		}
+	}
+	return fresh
+}
+
+// Print a watch event in the selected output format
+func printWatchEvent(event string, result Result, verbose bool, format string) {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(WatchEvent{Event: event, Result: result})
+		return
+	}
+	if format == "template" {
+		printTemplate(WatchEvent{Event: event, Result: result})
+		return
+	}
+
+	color := map[string]string{watchAdded: "ad", watchRemoved: "rm", watchChanged: "ch"}[event]
+	fmt.Printf("%s %s\n", colorize(color, event), result.Path)
+	if verbose {
+		for _, match := range result.Matches {
+			printMatchLine(result.Path, match)
+		}
+	}
+}
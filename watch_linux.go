@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Changes to directory entries and file content which can alter a match
+const watchDirMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// Time to wait for more events once one arrives, so a burst of writes to a
+// file is searched once
+const watchSettle = 50 * time.Millisecond
+
+// watcher reports changed paths below the watched directories and files.
+// Files are watched through their directory, limited to the watched names
+// and the paths they were given as.
+type watcher struct {
+	fd    int
+	file  *os.File
+	paths map[int32]string
+	files map[int32]map[string]string
+}
+
+func newWatcher() (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor is handled by the runtime poller, so reads
+	// can time out. Calling Fd would make it blocking again, keep it instead.
+	return &watcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), paths: make(map[int32]string), files: make(map[int32]map[string]string)}, nil
+}
+
+// Watch a directory for changes to its entries, or a file for changes to
+// it. A file is watched by name in its directory rather than by inode, so
+// it is still followed once log rotation moves it away and creates it anew.
+func (w *watcher) add(path string, dir bool) error {
+	watchPath := path
+	if !dir {
+		watchPath = filepath.Dir(path)
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, watchPath, watchDirMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// A directory watched in full stays so when a file in it is added
+	_, known := w.paths[int32(wd)]
+	files, limited := w.files[int32(wd)]
+	switch {
+	case dir:
+		delete(w.files, int32(wd))
+	case !known || limited:
+		if files == nil {
+			files = make(map[string]string)
+			w.files[int32(wd)] = files
+		}
+		files[filepath.Base(path)] = path
+	}
+	if dir || !known {
+		w.paths[int32(wd)] = watchPath
+	}
+	return nil
+}
+
+// Wait for changes and return the paths which changed, each only once
+func (w *watcher) next() ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	buf := make([]byte, 64*1024)
+
+	w.file.SetReadDeadline(time.Time{})
+	for {
+		n, err := w.file.Read(buf)
+		if os.IsTimeout(err) {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			var changed []string
+			switch {
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				// Events were lost, search everything watched again
+				for wd, path := range w.paths {
+					if files, limited := w.files[wd]; limited {
+						for _, file := range files {
+							changed = append(changed, file)
+						}
+					} else {
+						changed = append(changed, path)
+					}
+				}
+			case event.Mask&syscall.IN_IGNORED != 0:
+				delete(w.paths, event.Wd)
+				delete(w.files, event.Wd)
+				continue
+			default:
+				path, ok := w.paths[event.Wd]
+				if !ok {
+					continue
+				}
+				name := ""
+				if event.Len > 0 {
+					name = cString(nameBytes)
+					path = filepath.Join(path, name)
+				}
+				if files, limited := w.files[event.Wd]; limited {
+					// Only the watched files of the directory matter
+					file, watched := files[name]
+					if !watched {
+						continue
+					}
+					path = file
+				}
+				changed = append(changed, path)
+			}
+
+			for _, path := range changed {
+				if !seen[path] {
+					seen[path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+
+		// Collect whatever else arrives shortly after
+		w.file.SetReadDeadline(time.Now().Add(watchSettle))
+	}
+}
+
+// Utility function to trim the NUL padding of an inotify event name
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
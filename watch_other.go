@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errWatchUnsupported = errors.New("--watch is only supported on Linux")
+
+type watcher struct{}
+
+func newWatcher() (*watcher, error) {
+	return nil, errWatchUnsupported
+}
+
+func (w *watcher) add(path string, dir bool) error {
+	return errWatchUnsupported
+}
+
+func (w *watcher) next() ([]string, error) {
+	return nil, errWatchUnsupported
+}
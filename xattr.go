@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Extended attributes with special meaning to the kernel
+const (
+	xattrCapability = "security.capability"
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+)
+
+// Layout of the security.capability attribute, see linux/capability.h
+const (
+	vfsCapRevisionMask   = 0xff000000
+	vfsCapRevision1      = 0x01000000
+	vfsCapRevision2      = 0x02000000
+	vfsCapRevision3      = 0x03000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// Layout of the POSIX ACL attributes, see linux/posix_acl_xattr.h
+const (
+	aclVersion  = 0x0002
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// Capability names indexed by bit number
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap",
+	"cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Read extended attributes, ACLs and file capabilities into metadata
+func readXattrs(path string, metadata *Metadata) {
+	names, err := listXattrs(path)
+	if err != nil || len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	metadata.Xattrs = names
+
+	for _, name := range names {
+		switch name {
+		case xattrCapability:
+			if value, err := getXattr(path, name); err == nil {
+				metadata.Capabilities = decodeCapability(value)
+			}
+		case xattrACLAccess, xattrACLDefault:
+			value, err := getXattr(path, name)
+			if err != nil {
+				continue
+			}
+			prefix := ""
+			if name == xattrACLDefault {
+				prefix = "default:"
+			}
+			if acl := decodeACL(value, prefix); acl != "" {
+				if metadata.ACL != "" {
+					metadata.ACL += ","
+				}
+				metadata.ACL += acl
+			}
+		}
+	}
+}
+
+// Decode a security.capability value into getcap style text, e.g. cap_setuid+ep
+func decodeCapability(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+
+	magic := binary.LittleEndian.Uint32(data)
+	var permitted, inheritable uint64
+	var rootID uint32
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		if len(data) < 12 {
+			return ""
+		}
+		permitted = uint64(binary.LittleEndian.Uint32(data[4:]))
+		inheritable = uint64(binary.LittleEndian.Uint32(data[8:]))
+	case vfsCapRevision2, vfsCapRevision3:
+		if len(data) < 20 {
+			return ""
+		}
+		permitted = uint64(binary.LittleEndian.Uint32(data[4:])) | uint64(binary.LittleEndian.Uint32(data[12:]))<<32
+		inheritable = uint64(binary.LittleEndian.Uint32(data[8:])) | uint64(binary.LittleEndian.Uint32(data[16:]))<<32
+		if magic&vfsCapRevisionMask == vfsCapRevision3 && len(data) >= 24 {
+			rootID = binary.LittleEndian.Uint32(data[20:])
+		}
+	default:
+		return fmt.Sprintf("unknown revision 0x%08x", magic)
+	}
+	effective := magic&vfsCapFlagsEffective != 0
+
+	// Group capabilities sharing the same flags like cap_to_text does
+	var order []string
+	groups := make(map[string][]string)
+	for bit := 0; bit < 64; bit++ {
+		mask := uint64(1) << bit
+		flags := ""
+		if effective && permitted&mask != 0 {
+			flags += "e"
+		}
+		if inheritable&mask != 0 {
+			flags += "i"
+		}
+		if permitted&mask != 0 {
+			flags += "p"
+		}
+		if flags == "" {
+			continue
+		}
+		name := fmt.Sprintf("cap_%d", bit)
+		if bit < len(capabilityNames) {
+			name = capabilityNames[bit]
+		}
+		if _, ok := groups[flags]; !ok {
+			order = append(order, flags)
+		}
+		groups[flags] = append(groups[flags], name)
+	}
+
+	var clauses []string
+	for _, flags := range order {
+		clauses = append(clauses, strings.Join(groups[flags], ",")+"+"+flags)
+	}
+	text := strings.Join(clauses, " ")
+	if rootID != 0 {
+		text += fmt.Sprintf(" [rootid=%d]", rootID)
+	}
+	return text
+}
+
+// Decode a POSIX ACL attribute into getfacl style entries joined by commas
+func decodeACL(data []byte, prefix string) string {
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != aclVersion {
+		return ""
+	}
+
+	var entries []string
+	for data = data[4:]; len(data) >= 8; data = data[8:] {
+		tag := binary.LittleEndian.Uint16(data[0:])
+		perm := binary.LittleEndian.Uint16(data[2:])
+		id := binary.LittleEndian.Uint32(data[4:])
+
+		var qualifier string
+		switch tag {
+		case aclUserObj:
+			qualifier = "user::"
+		case aclUser:
+			qualifier = fmt.Sprintf("user:%d:", id)
+		case aclGroupObj:
+			qualifier = "group::"
+		case aclGroup:
+			qualifier = fmt.Sprintf("group:%d:", id)
+		case aclMask:
+			qualifier = "mask::"
+		case aclOther:
+			qualifier = "other::"
+		default:
+			continue
+		}
+
+		rwx := []byte("---")
+		if perm&4 != 0 {
+			rwx[0] = 'r'
+		}
+		if perm&2 != 0 {
+			rwx[1] = 'w'
+		}
+		if perm&1 != 0 {
+			rwx[2] = 'x'
+		}
+		entries = append(entries, prefix+qualifier+string(rwx))
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+func listXattrs(path string) ([]string, error) {
+	return nil, errXattrUnsupported
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}